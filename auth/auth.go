@@ -0,0 +1,180 @@
+// Package auth authenticates requests with HTTP basic auth against a bcrypt
+// htpasswd file or with bearer tokens.
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const realm = "PlantUML Watch Server"
+
+// Authenticator checks the credentials of requests. The zero value and nil
+// accept no credentials at all.
+type Authenticator struct {
+	users  map[string][]byte
+	tokens [][sha256.Size]byte
+
+	// verified remembers the last password that matched for every user,
+	// bcrypt is too slow to run for each request of a page
+	verifiedMu sync.Mutex
+	verified   map[string][sha256.Size]byte
+}
+
+// New loads the users of an htpasswd file with bcrypt hashes (htpasswd -B)
+// and the bearer tokens of tokenFile, one per line. Either path may be empty.
+func New(htpasswdPath, tokenFile string) (*Authenticator, error) {
+	a := &Authenticator{
+		users:    map[string][]byte{},
+		verified: map[string][sha256.Size]byte{},
+	}
+
+	if htpasswdPath != "" {
+		err := readLines(htpasswdPath, func(line string, number int) error {
+			user, hash, ok := strings.Cut(line, ":")
+			if !ok || user == "" {
+				return fmt.Errorf("%s:%d: expected user:hash", htpasswdPath, number)
+			}
+			if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+				return fmt.Errorf("%s:%d: password of %s is not a bcrypt hash, create it with htpasswd -B", htpasswdPath, number, user)
+			}
+
+			a.users[user] = []byte(hash)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if tokenFile != "" {
+		err := readLines(tokenFile, func(line string, _ int) error {
+			a.tokens = append(a.tokens, sha256.Sum256([]byte(line)))
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// Enabled reports whether any credentials are configured.
+func (a *Authenticator) Enabled() bool {
+	return a != nil && (len(a.users) > 0 || len(a.tokens) > 0)
+}
+
+// Authenticate reports whether r carries valid credentials.
+func (a *Authenticator) Authenticate(r *http.Request) bool {
+	if !a.Enabled() {
+		return false
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		return a.checkPassword(user, password)
+	}
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return a.checkToken(strings.TrimSpace(token))
+	}
+
+	return false
+}
+
+// Require serves next only for authenticated requests and asks for
+// credentials otherwise.
+func (a *Authenticator) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.Authenticate(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`", charset="UTF-8"`)
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireForWrites is Require for requests that change something, reads are
+// served without credentials.
+func (a *Authenticator) RequireForWrites(next http.Handler) http.Handler {
+	required := a.Require(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+		default:
+			required.ServeHTTP(w, r)
+		}
+	})
+}
+
+func (a *Authenticator) checkPassword(user, password string) bool {
+	hash, ok := a.users[user]
+	if !ok {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(password))
+	a.verifiedMu.Lock()
+	verified, ok := a.verified[user]
+	a.verifiedMu.Unlock()
+	if ok && subtle.ConstantTimeCompare(verified[:], sum[:]) == 1 {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	a.verifiedMu.Lock()
+	a.verified[user] = sum
+	a.verifiedMu.Unlock()
+
+	return true
+}
+
+func (a *Authenticator) checkToken(token string) bool {
+	sum := sha256.Sum256([]byte(token))
+	match := 0
+	for _, known := range a.tokens {
+		match |= subtle.ConstantTimeCompare(known[:], sum[:])
+	}
+
+	return match == 1
+}
+
+// readLines calls fn for every line of path that is neither empty nor a
+// comment.
+func readLines(path string, fn func(line string, number int) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	number := 0
+	for scanner.Scan() {
+		number++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := fn(line, number); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	return path
+}
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash failed: %v", err)
+	}
+
+	a, err := New(
+		writeFile(t, "# editors\nalice:"+string(hash)+"\n"),
+		writeFile(t, "token-1\n\ntoken-2\n"),
+	)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	a := newTestAuthenticator(t)
+	if !a.Enabled() {
+		t.Fatal("expected authenticator with credentials to be enabled")
+	}
+
+	tests := []struct {
+		name  string
+		setup func(r *http.Request)
+		want  bool
+	}{
+		{"no credentials", func(r *http.Request) {}, false},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, true},
+		{"basic again", func(r *http.Request) { r.SetBasicAuth("alice", "secret") }, true},
+		{"wrong password", func(r *http.Request) { r.SetBasicAuth("alice", "guess") }, false},
+		{"unknown user", func(r *http.Request) { r.SetBasicAuth("bob", "secret") }, false},
+		{"token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token-2") }, true},
+		{"wrong token", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token-3") }, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		test.setup(r)
+		if got := a.Authenticate(r); got != test.want {
+			t.Fatalf("%s: expected %v, got %v", test.name, test.want, got)
+		}
+	}
+
+	var disabled *Authenticator
+	if disabled.Enabled() || disabled.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)) {
+		t.Fatal("expected nil authenticator to accept nothing")
+	}
+}
+
+func TestRequireForWrites(t *testing.T) {
+	t.Parallel()
+
+	a := newTestAuthenticator(t)
+	handler := a.RequireForWrites(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected reads without credentials, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/", nil))
+	if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected writes to ask for credentials, got %d %v", rec.Code, rec.Header())
+	}
+
+	rec = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/", nil)
+	r.Header.Set("Authorization", "Bearer token-1")
+	handler.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected authenticated write, got %d", rec.Code)
+	}
+}
+
+func TestNewRejectsInvalidFiles(t *testing.T) {
+	t.Parallel()
+
+	for _, content := range []string{
+		"alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n",
+		"alice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\n",
+		"no separator\n",
+	} {
+		if _, err := New(writeFile(t, content), ""); err == nil {
+			t.Fatalf("expected error for htpasswd %q", content)
+		}
+	}
+
+	if _, err := New("", filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("expected error for missing token file")
+	}
+}
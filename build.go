@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/config"
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/mishankov/plantuml-watch-server/renderqueue"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// runBuild renders the input folder once, prints a summary and returns the
+// process exit code: 1 if any diagram failed to render.
+func runBuild(ctx context.Context, config *config.Config) int {
+	return runOnce(ctx, config, func(ctx context.Context, iw *inputwatcher.InputWatcher) int {
+		results, err := iw.Build(ctx)
+		if err != nil {
+			log.ErrorContext(ctx, "build failed", "error", err)
+			return 1
+		}
+
+		if failed := printBuildSummary(os.Stdout, results); failed > 0 {
+			return 1
+		}
+
+		return 0
+	})
+}
+
+// runOnce sets up rendering for commands that process the input folder once
+// instead of serving it, and returns the exit code of run.
+func runOnce(ctx context.Context, config *config.Config, run func(ctx context.Context, iw *inputwatcher.InputWatcher) int) int {
+	// stdout is reserved for the summary
+	log.SetDefault(log.New(os.Stderr, "text", log.LevelInfo, nil))
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	renderer := newRenderer(config)
+	if puml, ok := renderer.(*plantuml.PlantUML); ok {
+		workersCtx, stopWorkers := context.WithCancel(ctx)
+		workersDone := make(chan struct{})
+		go func() {
+			defer close(workersDone)
+			_ = puml.Run(workersCtx)
+		}()
+		defer func() {
+			stopWorkers()
+			<-workersDone
+		}()
+	}
+
+	cache, err := openCache(config)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to open render cache", "error", err)
+		return 1
+	}
+
+	return run(ctx, newInputWatcher(config, renderer, renderqueue.New(config.Concurrency), cache))
+}
+
+// printBuildSummary writes a table of the build results followed by the
+// errors of failed sources, and returns the number of failed sources.
+func printBuildSummary(w io.Writer, results []inputwatcher.BuildResult) int {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "STATUS\tSOURCE\tDIAGRAMS\tTIME")
+
+	diagrams := 0
+	failures := []inputwatcher.BuildResult{}
+	for _, result := range results {
+		status := "ok"
+		duration := result.Duration.Round(time.Millisecond).String()
+		switch {
+		case !result.Result.OK:
+			status = "FAILED"
+			failures = append(failures, result)
+		case result.Reused:
+			status = "cached"
+			duration = "-"
+		}
+
+		diagrams += len(result.Diagrams)
+		fmt.Fprintf(table, "%s\t%s\t%d\t%s\n", status, result.Source, len(result.Diagrams), duration)
+	}
+	table.Flush()
+
+	for _, failure := range failures {
+		fmt.Fprintf(w, "\n%s:\n", failure.Source)
+		if len(failure.Result.Diagnostics) == 0 {
+			fmt.Fprintf(w, "  %s\n", oneLine(failure.Result.Message))
+			continue
+		}
+
+		for _, diagnostic := range failure.Result.Diagnostics {
+			location := diagnostic.File
+			if diagnostic.Line > 0 {
+				location = fmt.Sprintf("%s:%d", location, diagnostic.Line)
+			}
+			fmt.Fprintf(w, "  %s: %s %s\n", location, diagnostic.Severity, oneLine(diagnostic.Message))
+			if diagnostic.Context != "" {
+				fmt.Fprintf(w, "    %s\n", strings.TrimSpace(diagnostic.Context))
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d sources, %d diagrams, %d failed\n", len(results), diagrams, len(failures))
+	return len(failures)
+}
+
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
@@ -6,30 +6,98 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
 )
 
+// Commands are the subcommands the application can be started with.
+var Commands = []string{"run", "build", "export"}
+
+// ErrUnknownCommand is returned for a subcommand that is not in Commands.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// Usage describes how to start the application.
+const Usage = `usage: plantuml-watch-server <run|build|export> [flags]
+Run "plantuml-watch-server run -h" to list the flags.`
+
 type Config struct {
-	PlantUMLPath string
-	InputFolder  string
-	OutputFolder string
-	Port         int
+	// Command is the subcommand the application was started with, e.g. run or build.
+	Command       string
+	PlantUMLPath  string
+	InputFolder   string
+	OutputFolder  string
+	Port          int
+	Workers       int
+	Concurrency   int
+	RenderTimeout time.Duration
+	Renderer      string
+	RendererURL   string
+	Watch         string
+	PollInterval  time.Duration
+	Debounce      time.Duration
+	CacheSize     int
+	Backups       int
+	History       int
+	Formats       []string
+	// Dest is the folder the export command writes the static site to.
+	Dest string
+	// ReadOnly disables editing sources from the browser.
+	ReadOnly bool
+	// Htpasswd and TokenFile hold the credentials accepted for writes, or for
+	// every request if Auth is "all".
+	Htpasswd  string
+	TokenFile string
+	Auth      string
 }
 
 func NewFromCLIArgs() (*Config, error) {
-	if len(os.Args) < 3 {
+	if len(os.Args) < 2 {
 		return NewFromArgs(nil)
 	}
 
-	return NewFromArgs(os.Args[2:])
+	// A typo must not start a server that never exits
+	if !slices.Contains(Commands, os.Args[1]) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCommand, os.Args[1])
+	}
+
+	config, err := NewFromArgs(os.Args[2:])
+	if err != nil {
+		return nil, err
+	}
+	config.Command = os.Args[1]
+
+	return config, nil
 }
 
 func NewFromArgs(args []string) (*Config, error) {
 	flagSet := flag.NewFlagSet("plantuml-watch-server", flag.ContinueOnError)
 
+	configFile := flagSet.String("config", "", "YAML or TOML config file, by default pumlws.yaml, pumlws.yml or pumlws.toml in the input folder if present")
 	plantUMLPath := flagSet.String("plantumlPath", "plantuml.jar", "path to plantuml.jar")
 	inputFolder := flagSet.String("input", "input", "input folder")
 	outputFolder := flagSet.String("output", "output", "output folder")
 	port := flagSet.Int("port", 8080, "server port")
+	concurrency := flagSet.Int("concurrency", runtime.NumCPU(), "maximum number of diagrams rendered at the same time")
+	renderTimeout := flagSet.Duration("renderTimeout", 2*time.Minute, "maximum duration of a single render (0 disables the limit)")
+	renderer := flagSet.String("renderer", "jar", "renderer backend: jar (local plantuml.jar) or http (PlantUML server)")
+	rendererURL := flagSet.String("rendererURL", "", "PlantUML server URL for the http renderer")
+	watch := flagSet.String("watch", "auto", "how to detect changes: auto, notify (file system events) or poll")
+	pollInterval := flagSet.Duration("pollInterval", time.Second, "how often the input folder is scanned when polling")
+	debounce := flagSet.Duration("debounce", 100*time.Millisecond, "how long changes must settle before diagrams are rendered")
+	cacheSize := flagSet.Int("cacheSize", 256, "maximum size of the render cache in megabytes (0 disables it)")
+	backups := flagSet.Int("backups", 20, "earlier versions kept of every source edited in the browser (0 disables backups)")
+	history := flagSet.Int("history", 20, "successful renders kept of every diagram for its timeline (0 disables the history)")
+	formats := flagSet.String("formats", "svg,png", "comma separated output formats offered for download: svg, png, pdf, eps, latex, txt, utxt, xmi")
+	dest := flagSet.String("dest", "site", "folder the export command writes the static site to")
+	readOnly := flagSet.Bool("readOnly", false, "disable editing sources from the browser")
+	htpasswd := flagSet.String("htpasswd", "", "htpasswd file with bcrypt passwords of users allowed to edit sources")
+	tokenFile := flagSet.String("tokenFile", "", "file with bearer tokens allowed to edit sources, one per line")
+	authScope := flagSet.String("auth", "writes", "requests that need credentials when -htpasswd or -tokenFile is set: writes or all")
+	workers := flagSet.Int("workers", 2, "persistent PlantUML processes per output format (0 starts java for every render)")
 
 	if err := flagSet.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
@@ -39,6 +107,100 @@ func NewFromArgs(args []string) (*Config, error) {
 		return nil, fmt.Errorf("parse flags: %w", err)
 	}
 
+	// Flags take precedence over PUMLWS_* environment variables, which take
+	// precedence over the config file
+	set := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	if err := applyEnv(flagSet, set); err != nil {
+		return nil, err
+	}
+
+	if *configFile == "" {
+		found, err := findConfigFile(*inputFolder)
+		if err != nil {
+			return nil, fmt.Errorf("find config file: %w", err)
+		}
+		*configFile = found
+	}
+
+	if *configFile != "" {
+		if err := applyConfigFile(flagSet, set, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if *workers < 0 {
+		return nil, fmt.Errorf("workers must not be negative, got %d", *workers)
+	}
+
+	if *concurrency < 1 {
+		return nil, fmt.Errorf("concurrency must be at least 1, got %d", *concurrency)
+	}
+
+	if *renderTimeout < 0 {
+		return nil, fmt.Errorf("renderTimeout must not be negative, got %s", *renderTimeout)
+	}
+
+	formatNames, err := parseFormats(*formats)
+	if err != nil {
+		return nil, err
+	}
+
+	switch *renderer {
+	case "jar":
+	case "http":
+		if *rendererURL == "" {
+			return nil, errors.New("rendererURL is required for the http renderer")
+		}
+
+		for _, name := range formatNames {
+			if format, _ := plantuml.LookupFormat(name); format.ServerPath == "" {
+				return nil, fmt.Errorf("format %q is not supported by the http renderer", name)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unknown renderer %q", *renderer)
+	}
+
+	if *backups < 0 {
+		return nil, fmt.Errorf("backups must not be negative, got %d", *backups)
+	}
+
+	if *history < 0 {
+		return nil, fmt.Errorf("history must not be negative, got %d", *history)
+	}
+
+	if *cacheSize < 0 {
+		return nil, fmt.Errorf("cacheSize must not be negative, got %d", *cacheSize)
+	}
+
+	switch *watch {
+	case "auto", "notify", "poll":
+	default:
+		return nil, fmt.Errorf("unknown watch mode %q", *watch)
+	}
+
+	if *pollInterval <= 0 {
+		return nil, fmt.Errorf("pollInterval must be positive, got %s", *pollInterval)
+	}
+
+	if *debounce <= 0 {
+		return nil, fmt.Errorf("debounce must be positive, got %s", *debounce)
+	}
+
+	switch *authScope {
+	case "writes":
+	case "all":
+		if *htpasswd == "" && *tokenFile == "" {
+			return nil, errors.New("auth all requires -htpasswd or -tokenFile")
+		}
+	default:
+		return nil, fmt.Errorf("unknown auth scope %q", *authScope)
+	}
+
 	inputFolderStr, err := filepath.Abs(*inputFolder)
 	if err != nil {
 		return nil, err
@@ -49,10 +211,51 @@ func NewFromArgs(args []string) (*Config, error) {
 		return nil, err
 	}
 
+	destStr, err := filepath.Abs(*dest)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Config{
-		PlantUMLPath: *plantUMLPath,
-		InputFolder:  inputFolderStr,
-		OutputFolder: outputFolderStr,
-		Port:         *port,
+		PlantUMLPath:  *plantUMLPath,
+		InputFolder:   inputFolderStr,
+		OutputFolder:  outputFolderStr,
+		Port:          *port,
+		Workers:       *workers,
+		Concurrency:   *concurrency,
+		RenderTimeout: *renderTimeout,
+		Renderer:      *renderer,
+		RendererURL:   *rendererURL,
+		Watch:         *watch,
+		PollInterval:  *pollInterval,
+		Debounce:      *debounce,
+		CacheSize:     *cacheSize,
+		Backups:       *backups,
+		History:       *history,
+		Formats:       formatNames,
+		Dest:          destStr,
+		ReadOnly:      *readOnly,
+		Htpasswd:      *htpasswd,
+		TokenFile:     *tokenFile,
+		Auth:          *authScope,
 	}, nil
 }
+
+// parseFormats splits a comma separated format list. SVG is always included
+// since the viewer shows it.
+func parseFormats(value string) ([]string, error) {
+	formats := []string{"svg"}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || slices.Contains(formats, name) {
+			continue
+		}
+
+		if _, ok := plantuml.LookupFormat(name); !ok {
+			return nil, fmt.Errorf("unknown format %q", name)
+		}
+		formats = append(formats, name)
+	}
+
+	return formats, nil
+}
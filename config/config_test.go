@@ -5,7 +5,9 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestNewFromArgsParsesFlagsWithoutSubcommand(t *testing.T) {
@@ -85,6 +87,35 @@ func TestNewFromCLIArgsDefaultsWithoutFlags(t *testing.T) {
 	if cfg.Port != 8080 {
 		t.Fatalf("expected default port 8080, got %d", cfg.Port)
 	}
+	if expectedDest, _ := filepath.Abs("site"); cfg.Dest != expectedDest {
+		t.Fatalf("expected dest folder %q, got %q", expectedDest, cfg.Dest)
+	}
+}
+
+func TestNewFromCLIArgsRejectsUnknownCommands(t *testing.T) {
+	originalArgs := os.Args
+	t.Cleanup(func() {
+		os.Args = originalArgs
+	})
+
+	for _, command := range []string{"biuld", "-input=./in", ""} {
+		os.Args = []string{"plantuml-watch-server", command}
+
+		cfg, err := NewFromCLIArgs()
+		if !errors.Is(err, ErrUnknownCommand) {
+			t.Fatalf("expected ErrUnknownCommand for %q, got %v", command, err)
+		}
+		if cfg != nil {
+			t.Fatalf("expected nil config for %q, got %#v", command, cfg)
+		}
+	}
+
+	for _, command := range Commands {
+		os.Args = []string{"plantuml-watch-server", command}
+		if _, err := NewFromCLIArgs(); err != nil {
+			t.Fatalf("expected %s to be accepted, got %v", command, err)
+		}
+	}
 }
 
 func TestNewFromArgsHelp(t *testing.T) {
@@ -116,3 +147,293 @@ func assertConfigPaths(t *testing.T, cfg *Config, inputPath, outputPath string)
 		t.Fatalf("expected output folder %q, got %q", expectedOutput, cfg.OutputFolder)
 	}
 }
+
+func TestNewFromArgsWorkers(t *testing.T) {
+	cfg, err := NewFromArgs(nil)
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Workers != 2 {
+		t.Fatalf("expected default of 2 workers, got %d", cfg.Workers)
+	}
+
+	cfg, err = NewFromArgs([]string{"-workers=0"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Workers != 0 {
+		t.Fatalf("expected workers to be disabled, got %d", cfg.Workers)
+	}
+
+	if _, err := NewFromArgs([]string{"-workers=-1"}); err == nil {
+		t.Fatal("expected error for negative workers")
+	}
+}
+
+func TestNewFromArgsRenderer(t *testing.T) {
+	cfg, err := NewFromArgs([]string{"-renderer=http", "-rendererURL=http://plantuml.local/plantuml"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Renderer != "http" || cfg.RendererURL != "http://plantuml.local/plantuml" {
+		t.Fatalf("unexpected renderer config: %#v", cfg)
+	}
+
+	if _, err := NewFromArgs([]string{"-renderer=http"}); err == nil {
+		t.Fatal("expected error when http renderer has no URL")
+	}
+	if _, err := NewFromArgs([]string{"-renderer=docker"}); err == nil {
+		t.Fatal("expected error for unknown renderer")
+	}
+}
+
+func TestNewFromArgsConcurrency(t *testing.T) {
+	cfg, err := NewFromArgs([]string{"-concurrency=3"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Concurrency != 3 {
+		t.Fatalf("expected concurrency 3, got %d", cfg.Concurrency)
+	}
+
+	if _, err := NewFromArgs([]string{"-concurrency=0"}); err == nil {
+		t.Fatal("expected error for zero concurrency")
+	}
+}
+
+func TestNewFromArgsRenderTimeout(t *testing.T) {
+	cfg, err := NewFromArgs([]string{"-renderTimeout=45s"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.RenderTimeout != 45*time.Second {
+		t.Fatalf("expected 45s render timeout, got %s", cfg.RenderTimeout)
+	}
+
+	if _, err := NewFromArgs([]string{"-renderTimeout=-1s"}); err == nil {
+		t.Fatal("expected error for negative render timeout")
+	}
+}
+
+func TestNewFromArgsWatch(t *testing.T) {
+	cfg, err := NewFromArgs([]string{"-watch=poll", "-pollInterval=5s", "-debounce=250ms"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Watch != "poll" || cfg.PollInterval != 5*time.Second || cfg.Debounce != 250*time.Millisecond {
+		t.Fatalf("unexpected watch config %q %s %s", cfg.Watch, cfg.PollInterval, cfg.Debounce)
+	}
+
+	if _, err := NewFromArgs([]string{"-watch=sometimes"}); err == nil {
+		t.Fatal("expected error for unknown watch mode")
+	}
+	if _, err := NewFromArgs([]string{"-pollInterval=0s"}); err == nil {
+		t.Fatal("expected error for zero poll interval")
+	}
+	if _, err := NewFromArgs([]string{"-debounce=0s"}); err == nil {
+		t.Fatal("expected error for zero debounce")
+	}
+}
+
+func TestNewFromArgsCacheSize(t *testing.T) {
+	cfg, err := NewFromArgs([]string{"-cacheSize=0"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.CacheSize != 0 {
+		t.Fatalf("expected disabled cache, got %d", cfg.CacheSize)
+	}
+
+	if _, err := NewFromArgs([]string{"-cacheSize=-1"}); err == nil {
+		t.Fatal("expected error for negative cache size")
+	}
+}
+
+func TestNewFromArgsBackups(t *testing.T) {
+	cfg, err := NewFromArgs([]string{})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Backups != 20 {
+		t.Fatalf("expected 20 backups by default, got %d", cfg.Backups)
+	}
+
+	if _, err := NewFromArgs([]string{"-backups=-1"}); err == nil {
+		t.Fatal("expected error for negative backups")
+	}
+}
+
+func TestNewFromArgsHistory(t *testing.T) {
+	cfg, err := NewFromArgs([]string{"-history=5"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.History != 5 {
+		t.Fatalf("expected 5 history entries, got %d", cfg.History)
+	}
+
+	if _, err := NewFromArgs([]string{"-history=-1"}); err == nil {
+		t.Fatal("expected error for negative history")
+	}
+}
+
+func TestNewFromArgsFormats(t *testing.T) {
+	cfg, err := NewFromArgs([]string{"-formats=pdf, txt,PDF"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if want := []string{"svg", "pdf", "txt"}; !reflect.DeepEqual(cfg.Formats, want) {
+		t.Fatalf("expected formats %v, got %v", want, cfg.Formats)
+	}
+
+	if _, err := NewFromArgs([]string{"-formats=gif"}); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+	if _, err := NewFromArgs([]string{"-renderer=http", "-rendererURL=http://localhost", "-formats=xmi"}); err == nil {
+		t.Fatal("expected error for format the http renderer can't produce")
+	}
+}
+
+func TestNewFromArgsConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "settings.yaml")
+	writeConfigFile(t, yamlFile, "input: diagrams\nport: 9000\nformats: [png, pdf]\nrenderTimeout: 30s\n")
+
+	cfg, err := NewFromArgs([]string{"-config=" + yamlFile})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if want := filepath.Join(dir, "diagrams"); cfg.InputFolder != want {
+		t.Fatalf("expected input folder relative to the config file %q, got %q", want, cfg.InputFolder)
+	}
+	if cfg.Port != 9000 {
+		t.Fatalf("expected port 9000, got %d", cfg.Port)
+	}
+	if want := []string{"svg", "png", "pdf"}; !reflect.DeepEqual(cfg.Formats, want) {
+		t.Fatalf("expected formats %v, got %v", want, cfg.Formats)
+	}
+	if cfg.RenderTimeout != 30*time.Second {
+		t.Fatalf("expected render timeout 30s, got %s", cfg.RenderTimeout)
+	}
+
+	tomlFile := filepath.Join(dir, "settings.toml")
+	writeConfigFile(t, tomlFile, "port = 9001\nworkers = 0\nwatch = \"poll\"\n")
+
+	cfg, err = NewFromArgs([]string{"-config=" + tomlFile})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Port != 9001 || cfg.Workers != 0 || cfg.Watch != "poll" {
+		t.Fatalf("unexpected config from TOML file: %+v", cfg)
+	}
+}
+
+func TestNewFromArgsConfigFileDiscoveredInInputFolder(t *testing.T) {
+	input := t.TempDir()
+	writeConfigFile(t, filepath.Join(input, "pumlws.toml"), "port = 9002\n")
+
+	cfg, err := NewFromArgs([]string{"-input=" + input})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Port != 9002 {
+		t.Fatalf("expected port from discovered config file, got %d", cfg.Port)
+	}
+}
+
+func TestNewFromArgsPrecedence(t *testing.T) {
+	input := t.TempDir()
+	writeConfigFile(t, filepath.Join(input, "pumlws.yaml"), "port: 9000\nworkers: 4\ncacheSize: 64\n")
+	t.Setenv("PUMLWS_INPUT", input)
+	t.Setenv("PUMLWS_PORT", "9100")
+	t.Setenv("PUMLWS_WORKERS", "3")
+
+	cfg, err := NewFromArgs([]string{"-port=9200"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.Port != 9200 {
+		t.Fatalf("expected flag to win, got port %d", cfg.Port)
+	}
+	if cfg.Workers != 3 {
+		t.Fatalf("expected environment to win over the config file, got %d workers", cfg.Workers)
+	}
+	if cfg.CacheSize != 64 {
+		t.Fatalf("expected config file to win over the default, got cache size %d", cfg.CacheSize)
+	}
+}
+
+func TestNewFromArgsConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	tests := map[string]string{
+		"unknown.yaml": "prot: 9000\n",
+		"invalid.yaml": "port: many\n",
+		"table.toml":   "[server]\nport = 9000\n",
+		"config.yaml":  "config: other.yaml\n",
+		"format.json":  "{}",
+	}
+	for name, content := range tests {
+		path := filepath.Join(dir, name)
+		writeConfigFile(t, path, content)
+
+		if _, err := NewFromArgs([]string{"-config=" + path}); err == nil {
+			t.Fatalf("expected error for config file %s", name)
+		}
+	}
+
+	if _, err := NewFromArgs([]string{"-config=" + filepath.Join(dir, "missing.yaml")}); err == nil {
+		t.Fatal("expected error for missing config file")
+	}
+
+	t.Setenv("PUMLWS_RENDER_TIMEOUT", "soon")
+	if _, err := NewFromArgs(nil); err == nil {
+		t.Fatal("expected error for invalid environment variable")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"input":         "PUMLWS_INPUT",
+		"plantumlPath":  "PUMLWS_PLANTUML_PATH",
+		"renderTimeout": "PUMLWS_RENDER_TIMEOUT",
+		"rendererURL":   "PUMLWS_RENDERER_URL",
+	}
+	for name, want := range tests {
+		if got := envName(name); got != want {
+			t.Fatalf("envName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write config file: %v", err)
+	}
+}
+
+func TestNewFromArgsAuth(t *testing.T) {
+	cfg, err := NewFromArgs([]string{"-readOnly", "-htpasswd=users", "-auth=all"})
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if !cfg.ReadOnly || cfg.Htpasswd != "users" || cfg.Auth != "all" {
+		t.Fatalf("unexpected auth config: %+v", cfg)
+	}
+
+	cfg, err = NewFromArgs(nil)
+	if err != nil {
+		t.Fatalf("NewFromArgs returned error: %v", err)
+	}
+	if cfg.ReadOnly || cfg.Auth != "writes" {
+		t.Fatalf("expected editable server with auth for writes by default, got %+v", cfg)
+	}
+
+	if _, err := NewFromArgs([]string{"-auth=all"}); err == nil {
+		t.Fatal("expected error for auth all without credentials")
+	}
+	if _, err := NewFromArgs([]string{"-auth=reads"}); err == nil {
+		t.Fatal("expected error for unknown auth scope")
+	}
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the environment variables that set flags, e.g.
+// PUMLWS_RENDER_TIMEOUT for -renderTimeout.
+const envPrefix = "PUMLWS_"
+
+// configFileNames are looked up in the input folder when no config file is
+// given.
+var configFileNames = []string{"pumlws.yaml", "pumlws.yml", "pumlws.toml"}
+
+// pathFlags hold paths, relative ones are resolved against the directory of
+// the config file that sets them.
+var pathFlags = []string{"plantumlPath", "input", "output", "dest", "htpasswd", "tokenFile"}
+
+// envName returns the environment variable that sets the flag name.
+func envName(name string) string {
+	var env strings.Builder
+	env.WriteString(envPrefix)
+	runes := []rune(name)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(runes[i-1]) {
+			env.WriteRune('_')
+		}
+		env.WriteRune(unicode.ToUpper(r))
+	}
+
+	return env.String()
+}
+
+// applyEnv sets flags that were not given on the command line from their
+// environment variables and marks them as set.
+func applyEnv(flagSet *flag.FlagSet, set map[string]bool) error {
+	var err error
+	flagSet.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] {
+			return
+		}
+
+		name := envName(f.Name)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+
+		if setErr := flagSet.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("environment variable %s: %w", name, setErr)
+			return
+		}
+		set[f.Name] = true
+	})
+
+	return err
+}
+
+// findConfigFile returns the config file in the input folder, if there is one.
+func findConfigFile(inputFolder string) (string, error) {
+	for _, name := range configFileNames {
+		path := filepath.Join(inputFolder, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// applyConfigFile sets flags that were neither given on the command line nor
+// in the environment from a YAML or TOML file. Keys are the flag names.
+func applyConfigFile(flagSet *flag.FlagSet, set map[string]bool, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read config file: %w", err)
+	}
+
+	values := map[string]any{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	default:
+		return fmt.Errorf("config file %s: unsupported format %q, use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		if key == "config" || flagSet.Lookup(key) == nil {
+			return fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+		if set[key] || values[key] == nil {
+			continue
+		}
+
+		value, err := configValue(values[key])
+		if err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+
+		if slices.Contains(pathFlags, key) && !filepath.IsAbs(value) {
+			value = filepath.Join(filepath.Dir(path), value)
+		}
+
+		if err := flagSet.Set(key, value); err != nil {
+			return fmt.Errorf("config file %s: %s: %w", path, key, err)
+		}
+		set[key] = true
+	}
+
+	return nil
+}
+
+// configValue returns a config file value the way it would be given as flag.
+// Lists are joined with commas.
+func configValue(value any) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return value, nil
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			if _, ok := item.([]any); ok {
+				return "", errors.New("nested lists are not supported")
+			}
+			itemValue, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, itemValue)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", errors.New("tables are not supported")
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/mishankov/plantuml-watch-server/config"
+	"github.com/mishankov/plantuml-watch-server/handlers"
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// runExport builds the input folder and writes the diagram pages as a static
+// site to the dest folder. Like build, it returns 1 if any diagram failed to
+// render, the site is written anyway and shows their error images.
+func runExport(ctx context.Context, config *config.Config) int {
+	tmpls, err := parseTemplates()
+	if err != nil {
+		log.ErrorContext(ctx, "failed to parse templates", "error", err)
+		return 1
+	}
+
+	static, err := fs.Sub(staticFiles, "static")
+	if err != nil {
+		log.ErrorContext(ctx, "failed to open static files", "error", err)
+		return 1
+	}
+
+	return runOnce(ctx, config, func(ctx context.Context, iw *inputwatcher.InputWatcher) int {
+		results, err := iw.Build(ctx)
+		if err != nil {
+			log.ErrorContext(ctx, "build failed", "error", err)
+			return 1
+		}
+		failed := printBuildSummary(os.Stdout, results)
+
+		exporter := handlers.NewSiteExporter(config.InputFolder, config.OutputFolder, tmpls, static, iw, config.Formats)
+		pages, err := exporter.Export(ctx, config.Dest)
+		if err != nil {
+			log.ErrorContext(ctx, "export failed", "error", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stdout, "exported %d diagrams to %s\n", pages, config.Dest)
+
+		if failed > 0 {
+			return 1
+		}
+
+		return 0
+	})
+}
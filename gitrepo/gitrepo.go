@@ -0,0 +1,116 @@
+// Package gitrepo reads files of a git repository as they were at any
+// revision, through the git command and without touching the working tree.
+package gitrepo
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	ErrNotRepository    = errors.New("not in a git repository")
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrFileNotFound     = errors.New("file not found at revision")
+)
+
+// Repo is a git repository.
+type Repo struct {
+	root string
+}
+
+// Open returns the repository dir is part of.
+func Open(ctx context.Context, dir string) (*Repo, error) {
+	out, err := git(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrNotRepository, dir, err)
+	}
+
+	return &Repo{root: filepath.Clean(strings.TrimSpace(string(out)))}, nil
+}
+
+// Root is the top level directory of the working tree, with symlinks
+// resolved.
+func (r *Repo) Root() string {
+	return r.root
+}
+
+// Rel returns path relative to the root, slash separated as git expects.
+// path must be below the root as returned by Root, symlinks are not resolved.
+func (r *Repo) Rel(path string) (string, bool) {
+	rel, err := filepath.Rel(r.root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return filepath.ToSlash(rel), true
+}
+
+// Resolve returns the commit a revision such as a branch, tag or abbreviated
+// commit refers to.
+func (r *Repo) Resolve(ctx context.Context, rev string) (string, error) {
+	// Revisions are passed to git, they must not be taken for options
+	if rev == "" || strings.HasPrefix(rev, "-") {
+		return "", fmt.Errorf("%w: %q", ErrRevisionNotFound, rev)
+	}
+
+	out, err := git(ctx, r.root, "rev-parse", "--verify", "--quiet", "--end-of-options", rev+"^{commit}")
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		return "", fmt.Errorf("%w: %s", ErrRevisionNotFound, rev)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// ReadFile returns the content of a file at a commit. path is relative to
+// the root.
+func (r *Repo) ReadFile(ctx context.Context, commit, path string) ([]byte, error) {
+	out, err := git(ctx, r.root, "show", commit+":"+path)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: %s at %s", ErrFileNotFound, path, commit)
+	}
+
+	return out, nil
+}
+
+// ResolvedPath returns path with the symlinks of its directory resolved, so
+// it can be related to the root. The directory must exist.
+func ResolvedPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := filepath.EvalSymlinks(filepath.Dir(abs))
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, filepath.Base(abs)), nil
+}
+
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("git %s: %w: %s", args[0], err, message)
+		}
+		return nil, fmt.Errorf("git %s: %w", args[0], err)
+	}
+
+	return out, nil
+}
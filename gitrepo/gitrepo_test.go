@@ -0,0 +1,87 @@
+package gitrepo
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/gitrepo/gitrepotest"
+)
+
+func TestRepoReadsFilesAtRevisions(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	gitrepotest.Init(t, dir)
+	if err := os.MkdirAll(filepath.Join(dir, "docs"), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	file := filepath.Join(dir, "docs", "flow.puml")
+	if err := os.WriteFile(file, []byte("first"), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	gitrepotest.Commit(t, dir, "first")
+	gitrepotest.Run(t, dir, "tag", "v1")
+	if err := os.WriteFile(file, []byte("second"), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	gitrepotest.Commit(t, dir, "second")
+	if err := os.WriteFile(file, []byte("working tree"), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	ctx := context.Background()
+	repo, err := Open(ctx, filepath.Join(dir, "docs"))
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	resolved, err := ResolvedPath(file)
+	if err != nil {
+		t.Fatalf("ResolvedPath failed: %v", err)
+	}
+	rel, ok := repo.Rel(resolved)
+	if !ok || rel != "docs/flow.puml" {
+		t.Fatalf("expected docs/flow.puml, got %q %v", rel, ok)
+	}
+
+	for rev, want := range map[string]string{"HEAD": "second", "v1": "first", "main~1": "first"} {
+		commit, err := repo.Resolve(ctx, rev)
+		if err != nil {
+			t.Fatalf("Resolve(%s) failed: %v", rev, err)
+		}
+
+		content, err := repo.ReadFile(ctx, commit, rel)
+		if err != nil || string(content) != want {
+			t.Fatalf("expected %q at %s, got %q %v", want, rev, content, err)
+		}
+	}
+
+	for _, rev := range []string{"missing", "--output=/tmp/x", ""} {
+		if _, err := repo.Resolve(ctx, rev); !errors.Is(err, ErrRevisionNotFound) {
+			t.Fatalf("expected %q not to resolve, got %v", rev, err)
+		}
+	}
+
+	commit, _ := repo.Resolve(ctx, "HEAD")
+	if _, err := repo.ReadFile(ctx, commit, "docs/missing.puml"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("expected missing file, got %v", err)
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil || string(content) != "working tree" {
+		t.Fatalf("expected the working tree to be left alone, got %q %v", content, err)
+	}
+}
+
+func TestOpenOutsideRepository(t *testing.T) {
+	t.Parallel()
+
+	gitrepotest.SkipWithoutGit(t)
+
+	if _, err := Open(context.Background(), t.TempDir()); !errors.Is(err, ErrNotRepository) {
+		t.Fatalf("expected ErrNotRepository, got %v", err)
+	}
+}
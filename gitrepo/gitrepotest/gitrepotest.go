@@ -0,0 +1,46 @@
+// Package gitrepotest creates git repositories for tests.
+package gitrepotest
+
+import (
+	"os/exec"
+	"testing"
+)
+
+// SkipWithoutGit skips the test if the git command is not installed.
+func SkipWithoutGit(t testing.TB) {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+}
+
+// Init creates a repository in dir with git identity settings that don't
+// depend on the environment. The test is skipped without git.
+func Init(t testing.TB, dir string) {
+	t.Helper()
+
+	SkipWithoutGit(t)
+	Run(t, dir, "init", "--quiet", "--initial-branch=main")
+	Run(t, dir, "config", "user.email", "test@example.com")
+	Run(t, dir, "config", "user.name", "Test")
+	Run(t, dir, "config", "commit.gpgsign", "false")
+}
+
+// Commit stages every change in dir and commits it.
+func Commit(t testing.TB, dir, message string) {
+	t.Helper()
+
+	Run(t, dir, "add", "-A")
+	Run(t, dir, "commit", "--quiet", "-m", message)
+}
+
+// Run runs git in dir and fails the test if it fails.
+func Run(t testing.TB, dir string, args ...string) {
+	t.Helper()
+
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v failed: %v\n%s", args, err, out)
+	}
+}
@@ -3,12 +3,17 @@ module github.com/mishankov/plantuml-watch-server
 go 1.25.0
 
 require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/fsnotify/fsnotify v1.9.0
 	github.com/gorilla/websocket v1.5.3
 	github.com/platforma-dev/platforma v0.1.0-alpha.24
+	golang.org/x/crypto v0.49.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/jmoiron/sqlx v1.4.0 // indirect
 	github.com/lib/pq v1.12.1 // indirect
+	golang.org/x/sys v0.42.0 // indirect
 )
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+)
+
+// DiagramsAPIHandler serves the diagram catalogue as JSON: GET /api/diagrams
+// lists every diagram, GET /api/diagrams/{name} returns one and GET
+// /api/diagrams/{name}/history its earlier renders.
+type DiagramsAPIHandler struct {
+	inputWatcher *inputwatcher.InputWatcher
+	history      *HistoryAPIHandler
+}
+
+type diagramsResponse struct {
+	Diagrams []diagramResponse `json:"diagrams"`
+}
+
+type diagramResponse struct {
+	Name             string           `json:"name"`
+	URL              string           `json:"url"`
+	SourcePath       string           `json:"sourcePath"`
+	Outputs          []outputResponse `json:"outputs"`
+	Compile          *compileStatus   `json:"compile,omitempty"`
+	SourceModifiedAt time.Time        `json:"sourceModifiedAt,omitzero"`
+	RenderedAt       time.Time        `json:"renderedAt,omitzero"`
+	RenderDurationMs int64            `json:"renderDurationMs,omitempty"`
+}
+
+type outputResponse struct {
+	Format string `json:"format"`
+	Path   string `json:"path"`
+	URL    string `json:"url"`
+}
+
+func NewDiagramsAPIHandler(inputWatcher *inputwatcher.InputWatcher) *DiagramsAPIHandler {
+	return &DiagramsAPIHandler{inputWatcher: inputWatcher, history: NewHistoryAPIHandler(inputWatcher)}
+}
+
+func (h *DiagramsAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := r.PathValue("name")
+	if name == "" {
+		response := diagramsResponse{Diagrams: []diagramResponse{}}
+		for _, status := range h.inputWatcher.Diagrams() {
+			response.Diagrams = append(response.Diagrams, newDiagramResponse(status))
+		}
+
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	status, ok := h.inputWatcher.Diagram(path.Clean(name))
+	if !ok {
+		// A diagram named history wins over the history of its folder, which
+		// stays reachable under /api/history
+		if diagram, ok := strings.CutSuffix(path.Clean(name), "/history"); ok {
+			h.history.serve(w, r, diagram)
+			return
+		}
+
+		http.Error(w, "diagram not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newDiagramResponse(status))
+}
+
+func newDiagramResponse(status inputwatcher.DiagramStatus) diagramResponse {
+	response := diagramResponse{
+		Name:             status.Name,
+		URL:              "/output/" + escapePath(status.Name),
+		SourcePath:       status.Source,
+		Outputs:          []outputResponse{},
+		SourceModifiedAt: status.SourceModified,
+	}
+
+	if status.Compiled {
+		response.Compile = newCompileStatus(status.Result)
+		response.RenderedAt = status.RenderedAt
+		response.RenderDurationMs = status.RenderDuration.Milliseconds()
+	}
+
+	for _, output := range status.Outputs {
+		ext := strings.TrimPrefix(path.Ext(output), ".")
+		for _, format := range plantuml.Formats() {
+			if format.Extension != ext {
+				continue
+			}
+
+			response.Outputs = append(response.Outputs, outputResponse{
+				Format: format.Name,
+				Path:   output,
+				URL:    "/download/" + escapePath(status.Name) + "?ext=" + format.Name,
+			})
+			break
+		}
+	}
+
+	return response
+}
+
+// escapePath escapes every segment of a slash separated diagram name.
+func escapePath(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	return strings.Join(segments, "/")
+}
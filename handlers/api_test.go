@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+)
+
+func TestDiagramsAPIHandler(t *testing.T) {
+	t.Parallel()
+
+	iw, _, _ := newTestWatcher(t, map[string]string{
+		"docs/flow.puml": "@startuml\nA -> B\n@enduml\n",
+		"broken.puml":    "@startuml\nbroken\n@enduml\n",
+	}, inputwatcher.Options{})
+	if _, err := iw.OutputForDiagram(context.Background(), "docs/flow", "png", plantuml.RenderOptions{}); err != nil {
+		t.Fatalf("OutputForDiagram failed: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	handler := NewDiagramsAPIHandler(iw)
+	mux.Handle("/api/diagrams", handler)
+	mux.Handle("/api/diagrams/{name...}", handler)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diagrams", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+
+	var list diagramsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(list.Diagrams) != 2 || list.Diagrams[0].Name != "broken" || list.Diagrams[1].Name != "docs/flow" {
+		t.Fatalf("unexpected diagrams %#v", list.Diagrams)
+	}
+	if compile := list.Diagrams[0].Compile; compile == nil || compile.OK || len(compile.Diagnostics) == 0 {
+		t.Fatalf("expected failed compile with diagnostics, got %#v", compile)
+	}
+
+	flow := list.Diagrams[1]
+	if flow.SourcePath != "docs/flow.puml" || flow.URL != "/output/docs/flow" {
+		t.Fatalf("unexpected diagram %#v", flow)
+	}
+	if flow.Compile == nil || !flow.Compile.OK || flow.RenderedAt.IsZero() || flow.SourceModifiedAt.IsZero() {
+		t.Fatalf("expected successful compile with timestamps, got %#v", flow)
+	}
+	if len(flow.Outputs) != 2 || flow.Outputs[0].Format != "png" || flow.Outputs[1].URL != "/download/docs/flow?ext=svg" {
+		t.Fatalf("unexpected outputs %#v", flow.Outputs)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diagrams/docs/flow", nil))
+	var single diagramResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &single); err != nil || single.Name != "docs/flow" {
+		t.Fatalf("unexpected single diagram response %d %s", rec.Code, rec.Body)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diagrams/missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rec.Code)
+	}
+}
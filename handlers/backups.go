@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// BackupsHandler serves the backups of a diagram's source: GET
+// /backups/{name} lists them, GET /backups/{name}?id=... returns one and
+// POST /backups/{name}?id=... restores it.
+type BackupsHandler struct {
+	inputWatcher *inputwatcher.InputWatcher
+	readOnly     bool
+}
+
+type backupsResponse struct {
+	Diagram string           `json:"diagram"`
+	Backups []backupResponse `json:"backups"`
+}
+
+type backupResponse struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+	Size      int64     `json:"size"`
+	Content   string    `json:"content,omitempty"`
+}
+
+// NewBackupsHandler serves backups, restoring them is disabled if readOnly is
+// set.
+func NewBackupsHandler(inputWatcher *inputwatcher.InputWatcher, readOnly bool) *BackupsHandler {
+	return &BackupsHandler{inputWatcher: inputWatcher, readOnly: readOnly}
+}
+
+func (h *BackupsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	diagram := filepath.Clean(r.PathValue("name"))
+	id := r.URL.Query().Get("id")
+
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		h.handleList(w, r, diagram)
+	case r.Method == http.MethodGet:
+		h.handleGet(w, r, diagram, id)
+	case r.Method == http.MethodPost && !h.readOnly:
+		h.handleRestore(w, r, diagram, id)
+	case r.Method == http.MethodPost:
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "the server is read-only", http.StatusMethodNotAllowed)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *BackupsHandler) handleList(w http.ResponseWriter, r *http.Request, diagram string) {
+	backups, err := h.inputWatcher.Backups(diagram)
+	if err != nil {
+		writeSourceError(w, r, diagram, err, "failed to list backups")
+		return
+	}
+
+	response := backupsResponse{Diagram: diagram, Backups: []backupResponse{}}
+	for _, backup := range backups {
+		response.Backups = append(response.Backups, backupResponse{
+			ID:        backup.ID,
+			CreatedAt: backup.CreatedAt,
+			Size:      backup.Size,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (h *BackupsHandler) handleGet(w http.ResponseWriter, r *http.Request, diagram, id string) {
+	content, err := h.inputWatcher.ReadBackup(diagram, id)
+	if errors.Is(err, inputwatcher.ErrBackupNotFound) {
+		http.Error(w, "backup not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		writeSourceError(w, r, diagram, err, "failed to read backup")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, backupResponse{ID: id, Size: int64(len(content)), Content: content})
+}
+
+func (h *BackupsHandler) handleRestore(w http.ResponseWriter, r *http.Request, diagram, id string) {
+	// Restoring overwrites the source like a save from the editor does
+	baseVersion, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
+	log.InfoContext(r.Context(), "restoring diagram source", "diagram", diagram, "backup", id)
+
+	source, result, err := h.inputWatcher.RestoreBackup(r.Context(), diagram, id, baseVersion)
+	if errors.Is(err, inputwatcher.ErrBackupNotFound) {
+		http.Error(w, "backup not found", http.StatusNotFound)
+		return
+	}
+
+	writeSaveResult(w, r, diagram, source, result, err)
+}
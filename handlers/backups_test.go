@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+)
+
+func TestBackupsHandlerListsAndRestores(t *testing.T) {
+	t.Parallel()
+
+	iw, source := newSourceTestWatcher(t, inputwatcher.Options{Backups: 5})
+	mux := http.NewServeMux()
+	mux.Handle("/source/{name...}", NewSourceHandler(iw, false))
+	mux.Handle("/backups/{name...}", NewBackupsHandler(iw, false))
+
+	put := httptest.NewRequest(http.MethodPut, "/source/flow", strings.NewReader(`{"content":"@startuml\nB -> C\n@enduml\n"}`))
+	put.Header.Set("If-Match", "*")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, put)
+	var saved sourceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &saved); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("expected save, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backups/flow", nil))
+	var list backupsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if rec.Code != http.StatusOK || len(list.Backups) != 1 || list.Backups[0].CreatedAt.IsZero() {
+		t.Fatalf("expected a single backup, got %d %s", rec.Code, rec.Body.String())
+	}
+	id := list.Backups[0].ID
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backups/flow?id="+id, nil))
+	var backup backupResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &backup); err != nil || backup.Content != "@startuml\nA -> B\n@enduml\n" {
+		t.Fatalf("expected the backup content, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backups/flow?id=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown backup, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/backups/flow?id="+id, nil))
+	if rec.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428 without If-Match, got %d", rec.Code)
+	}
+
+	restore := httptest.NewRequest(http.MethodPost, "/backups/flow?id="+id, nil)
+	restore.Header.Set("If-Match", `"`+saved.Version+`"`)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, restore)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected restore, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	content, err := os.ReadFile(source)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(content) != "@startuml\nA -> B\n@enduml\n" {
+		t.Fatalf("expected the backup to be restored, got %q", content)
+	}
+}
+
+func TestBackupsHandlerReadOnly(t *testing.T) {
+	t.Parallel()
+
+	iw, _ := newSourceTestWatcher(t, inputwatcher.Options{Backups: 5})
+	mux := http.NewServeMux()
+	mux.Handle("/backups/{name...}", NewBackupsHandler(iw, true))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/backups/flow", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected backups to be listed, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/backups/flow?id=20000101T000000.000000000Z", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET" {
+		t.Fatalf("expected restore to be disabled, got %d", rec.Code)
+	}
+}
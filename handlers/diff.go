@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/mishankov/plantuml-watch-server/gitrepo"
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/mishankov/plantuml-watch-server/svgdiff"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// errDiffUnavailable is returned when a version to compare can't be loaded.
+var errDiffUnavailable = errors.New("diagram version not found")
+
+// DiffSide is one of the two diagram versions compared.
+type DiffSide struct {
+	Diagram string
+	// Version is the ID of an entry of the render history, the current
+	// render if empty.
+	Version string
+	// Rev is a git revision to render the diagram at instead, it takes
+	// precedence over Version.
+	Rev string
+}
+
+// diffSides reads the versions to compare from the query: before and after
+// name the diagrams, beforeVersion and afterVersion pick entries of their
+// render history and beforeRev and afterRev git revisions. after defaults to
+// the before diagram.
+func diffSides(query url.Values) (DiffSide, DiffSide, error) {
+	before := DiffSide{Diagram: query.Get("before"), Version: query.Get("beforeVersion"), Rev: query.Get("beforeRev")}
+	after := DiffSide{Diagram: query.Get("after"), Version: query.Get("afterVersion"), Rev: query.Get("afterRev")}
+	if before.Diagram == "" {
+		return DiffSide{}, DiffSide{}, errors.New("the diagram to compare is missing, set before")
+	}
+	if after.Diagram == "" {
+		after.Diagram = before.Diagram
+	}
+
+	before.Diagram = path.Clean(before.Diagram)
+	after.Diagram = path.Clean(after.Diagram)
+	return before, after, nil
+}
+
+// compareDiagrams loads both versions and compares their SVGs.
+func compareDiagrams(ctx context.Context, iw *inputwatcher.InputWatcher, before, after DiffSide) (svgdiff.Result, error) {
+	beforeSVG, err := loadDiffSide(ctx, iw, before)
+	if err != nil {
+		return svgdiff.Result{}, err
+	}
+
+	afterSVG, err := loadDiffSide(ctx, iw, after)
+	if err != nil {
+		return svgdiff.Result{}, err
+	}
+
+	return svgdiff.Compare(beforeSVG, afterSVG)
+}
+
+func loadDiffSide(ctx context.Context, iw *inputwatcher.InputWatcher, side DiffSide) ([]byte, error) {
+	if side.Rev != "" {
+		_, svg, err := iw.RenderAtRevision(ctx, side.Diagram, side.Rev)
+		switch {
+		case errors.Is(err, gitrepo.ErrNotRepository),
+			errors.Is(err, gitrepo.ErrRevisionNotFound),
+			errors.Is(err, gitrepo.ErrFileNotFound),
+			errors.Is(err, inputwatcher.ErrOutputNotTracked):
+			return nil, fmt.Errorf("%w: %s at %s: %w", errDiffUnavailable, side.Diagram, side.Rev, err)
+		}
+
+		return svg, err
+	}
+
+	if side.Version != "" {
+		svg, _, err := iw.ReadHistory(side.Diagram, side.Version)
+		if errors.Is(err, inputwatcher.ErrHistoryNotFound) || errors.Is(err, inputwatcher.ErrOutputNotTracked) {
+			return nil, fmt.Errorf("%w: %s at %s", errDiffUnavailable, side.Diagram, side.Version)
+		}
+
+		return []byte(svg), err
+	}
+
+	svgFile, err := iw.OutputForDiagram(ctx, side.Diagram, "svg", plantuml.RenderOptions{})
+	if errors.Is(err, inputwatcher.ErrOutputNotTracked) {
+		return nil, fmt.Errorf("%w: %s", errDiffUnavailable, side.Diagram)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(svgFile)
+}
+
+// diffErrorStatus returns the status code of a failed comparison.
+func diffErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errDiffUnavailable):
+		return http.StatusNotFound
+	case errors.Is(err, inputwatcher.ErrRenderFailed):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// DiffHandler serves a page comparing two versions of diagrams side by side
+// and overlaid, with the elements that differ highlighted.
+type DiffHandler struct {
+	inputWatcher *inputwatcher.InputWatcher
+	templates    *template.Template
+}
+
+type DiffPageData struct {
+	Before DiffSide
+	After  DiffSide
+	// BeforeSVG and AfterSVG have the elements that differ marked
+	BeforeSVG template.HTML
+	AfterSVG  template.HTML
+	Result    svgdiff.Result
+	// Diagrams and the histories of both sides can be picked for comparison
+	Diagrams      []string
+	BeforeHistory []inputwatcher.HistoryEntry
+	AfterHistory  []inputwatcher.HistoryEntry
+}
+
+func NewDiffHandler(inputWatcher *inputwatcher.InputWatcher, templates *template.Template) *DiffHandler {
+	return &DiffHandler{inputWatcher: inputWatcher, templates: templates}
+}
+
+func (h *DiffHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	before, after, err := diffSides(r.URL.Query())
+	if err != nil {
+		renderErrorPage(w, r, h.templates, http.StatusBadRequest, "Pick the diagram to compare.")
+		return
+	}
+
+	result, err := compareDiagrams(r.Context(), h.inputWatcher, before, after)
+	if err != nil {
+		status := diffErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.ErrorContext(r.Context(), "failed to compare diagrams", "before", before.Diagram, "after", after.Diagram, "error", err)
+		}
+		renderErrorPage(w, r, h.templates, status, "Unable to compare the diagrams: "+err.Error())
+		return
+	}
+
+	data := DiffPageData{
+		Before:    before,
+		After:     after,
+		BeforeSVG: trustedSVG(result.Before),
+		AfterSVG:  trustedSVG(result.After),
+		Result:    result,
+	}
+	for _, status := range h.inputWatcher.Diagrams() {
+		data.Diagrams = append(data.Diagrams, status.Name)
+	}
+	// The pickers just offer no earlier renders if the history is unreadable
+	data.BeforeHistory, _ = h.inputWatcher.History(before.Diagram)
+	data.AfterHistory, _ = h.inputWatcher.History(after.Diagram)
+
+	if err := renderHTMLTemplate(w, h.templates, "diff.html", data); err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// DiffAPIHandler serves the comparison of two versions of diagrams as JSON,
+// with the same query as the page.
+type DiffAPIHandler struct {
+	inputWatcher *inputwatcher.InputWatcher
+}
+
+type diffResponse struct {
+	Before    diffSideResponse `json:"before"`
+	After     diffSideResponse `json:"after"`
+	Added     int              `json:"added"`
+	Removed   int              `json:"removed"`
+	Moved     int              `json:"moved"`
+	Unchanged int              `json:"unchanged"`
+}
+
+type diffSideResponse struct {
+	Diagram string `json:"diagram"`
+	Version string `json:"version,omitempty"`
+	Rev     string `json:"rev,omitempty"`
+	SVG     string `json:"svg"`
+}
+
+func NewDiffAPIHandler(inputWatcher *inputwatcher.InputWatcher) *DiffAPIHandler {
+	return &DiffAPIHandler{inputWatcher: inputWatcher}
+}
+
+func (h *DiffAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	before, after, err := diffSides(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := compareDiagrams(r.Context(), h.inputWatcher, before, after)
+	if err != nil {
+		status := diffErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.ErrorContext(r.Context(), "failed to compare diagrams", "before", before.Diagram, "after", after.Diagram, "error", err)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, diffResponse{
+		Before:    diffSideResponse{Diagram: before.Diagram, Version: before.Version, Rev: before.Rev, SVG: string(result.Before)},
+		After:     diffSideResponse{Diagram: after.Diagram, Version: after.Version, Rev: after.Rev, SVG: string(result.After)},
+		Added:     result.Added,
+		Removed:   result.Removed,
+		Moved:     result.Moved,
+		Unchanged: result.Unchanged,
+	})
+}
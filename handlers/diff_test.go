@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+)
+
+func newDiffTestWatcher(t *testing.T) *inputwatcher.InputWatcher {
+	t.Helper()
+
+	iw, _, _ := newTestWatcher(t, map[string]string{
+		"flow.puml":  "@startuml\nA -> B\n@enduml\n",
+		"other.puml": "@startuml\nA -> B\nB -> C\n@enduml\n",
+	}, inputwatcher.Options{History: 5})
+
+	return iw
+}
+
+func TestDiffAPIHandlerComparesHistory(t *testing.T) {
+	t.Parallel()
+
+	iw := newDiffTestWatcher(t)
+	if _, _, err := iw.WriteSourceForOutput(context.Background(), "flow", "@startuml\nA -> C\n@enduml\n", ""); err != nil {
+		t.Fatalf("WriteSourceForOutput failed: %v", err)
+	}
+	history, err := iw.History("flow")
+	if err != nil || len(history) != 2 {
+		t.Fatalf("expected 2 renders, got %#v %v", history, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/diff", NewDiffAPIHandler(iw))
+
+	query := url.Values{"before": {"flow"}, "beforeVersion": {history[1].ID}}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diff?"+query.Encode(), nil))
+	var response diffResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if rec.Code != http.StatusOK || response.After.Diagram != "flow" || response.After.Version != "" {
+		t.Fatalf("expected comparison with the current render, got %d %s", rec.Code, rec.Body.String())
+	}
+	if response.Added != 1 || response.Removed != 1 || response.Unchanged != 2 {
+		t.Fatalf("unexpected counts %+v", response)
+	}
+	if !strings.Contains(response.Before.SVG, `<text data-diff="removed" x="0" y="20">A -&gt; B</text>`) ||
+		!strings.Contains(response.After.SVG, `<text data-diff="added" x="0" y="20">A -&gt; C</text>`) {
+		t.Fatalf("expected the changed line to be marked, got %s %s", response.Before.SVG, response.After.SVG)
+	}
+
+	for target, status := range map[string]int{
+		"/api/diff":                http.StatusBadRequest,
+		"/api/diff?before=missing": http.StatusNotFound,
+		"/api/diff?before=flow&beforeVersion=not-an-entry": http.StatusNotFound,
+	} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != status {
+			t.Fatalf("expected %d for %s, got %d", status, target, rec.Code)
+		}
+	}
+}
+
+func TestDiffHandlerComparesDiagrams(t *testing.T) {
+	t.Parallel()
+
+	templates, err := template.New("").Funcs(ServerLinks()).ParseGlob("../templates/*.html")
+	if err != nil {
+		t.Fatalf("parse templates failed: %v", err)
+	}
+
+	iw := newDiffTestWatcher(t)
+	mux := http.NewServeMux()
+	mux.Handle("/diff", NewDiffHandler(iw, templates))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/diff?before=flow&after=other", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the diff page, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{
+		`<text data-diff="moved" x="0" y="40">@enduml</text>`,
+		`<text data-diff="added" x="0" y="40">B -&gt; C</text>`,
+		"1 added",
+		"1 moved",
+		`<option value="other" selected>other</option>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in the page, got %s", want, body)
+		}
+	}
+}
+
+func TestDiffAPIHandlerComparesWithRevision(t *testing.T) {
+	t.Parallel()
+
+	iw, _ := newGitTestWatcher(t)
+	mux := http.NewServeMux()
+	mux.Handle("/api/diff", NewDiffAPIHandler(iw))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diff?before=flow&beforeRev=HEAD", nil))
+	var response diffResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if rec.Code != http.StatusOK || response.Before.Rev != "HEAD" || response.After.Rev != "" {
+		t.Fatalf("expected HEAD compared with the working tree, got %d %s", rec.Code, rec.Body.String())
+	}
+	if response.Added != 1 || response.Removed != 1 || response.Unchanged != 2 {
+		t.Fatalf("unexpected counts %+v", response)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diff?before=flow&beforeRev=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown revision, got %d", rec.Code)
+	}
+}
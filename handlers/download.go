@@ -1,40 +1,91 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/platforma-dev/platforma/log"
 )
 
+// diagramOutputs renders diagrams in a given format on demand.
+type diagramOutputs interface {
+	OutputForDiagram(ctx context.Context, diagram, format string, opts plantuml.RenderOptions) (string, error)
+}
+
 type DownloadHandler struct {
-	outputFolder string
+	outputs diagramOutputs
 }
 
-func NewDownloadHandler(outputFolder string) *DownloadHandler {
-	return &DownloadHandler{outputFolder: outputFolder}
+func NewDownloadHandler(outputs diagramOutputs) *DownloadHandler {
+	return &DownloadHandler{outputs: outputs}
 }
 
 func (h *DownloadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	name := r.PathValue("name")
-	ext := r.URL.Query().Get("ext")
-	path := filepath.Join(h.outputFolder, name+"."+ext)
+	name := filepath.Clean(r.PathValue("name"))
+	query := r.URL.Query()
+	ext := query.Get("ext")
+
+	format, ok := plantuml.LookupFormat(ext)
+	if !ok {
+		w.WriteHeader(400)
+		w.Write([]byte("Unsupported format: " + ext))
+		return
+	}
+
+	opts, err := plantuml.ParseRenderOptions(query.Get("scale"), query.Get("dpi"), query.Get("theme"))
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) || filepath.IsAbs(name) {
+		w.WriteHeader(400)
+		w.Write([]byte("Invalid path"))
+		return
+	}
+
+	// Formats other than SVG and variants with options are rendered on the first download
+	path, err := h.outputs.OutputForDiagram(r.Context(), name, format.Name, opts)
+	if err != nil {
+		switch {
+		case errors.Is(err, inputwatcher.ErrOutputNotTracked):
+			w.WriteHeader(404)
+			w.Write([]byte(format.Label + " file not found"))
+		case errors.Is(err, inputwatcher.ErrRenderFailed):
+			log.WarnContext(r.Context(), "failed to render download", "diagram", name, "format", format.Name, "error", err)
+			w.WriteHeader(422)
+			w.Write([]byte(err.Error()))
+		default:
+			log.ErrorContext(r.Context(), "failed to render download", "diagram", name, "format", format.Name, "error", err)
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+		}
+		return
+	}
+
+	fileName := filepath.Base(name)
+	if !opts.IsZero() {
+		fileName += "-" + opts.Key()
+	}
+	fileName += "." + format.Extension
 
 	data, err := os.ReadFile(path)
 	if err != nil {
 		w.WriteHeader(404)
-		w.Write([]byte("SVG file not found: " + err.Error()))
+		w.Write([]byte(format.Label + " file not found: " + err.Error()))
 		return
 	}
 
-	switch ext {
-	case "svg":
-		w.Header().Set("Content-Type", "image/svg+xml")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.svg", filepath.Base(name)))
-	case "png":
-		w.Header().Set("Content-Type", "image/png")
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.png", filepath.Base(name)))
-	}
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", fileName))
 
 	w.Write(data)
 }
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+)
+
+// stubOutputs serves pre-rendered files, failing for diagrams named "broken".
+type stubOutputs struct {
+	root string
+}
+
+func (s stubOutputs) OutputForDiagram(_ context.Context, diagram, format string, opts plantuml.RenderOptions) (string, error) {
+	if diagram == "broken" {
+		return "", inputwatcher.ErrRenderFailed
+	}
+
+	if !opts.IsZero() {
+		diagram += "-" + opts.Key()
+	}
+	path := filepath.Join(s.root, diagram+"."+plantuml.FormatExtension(format))
+	if _, err := os.Stat(path); err != nil {
+		return "", inputwatcher.ErrOutputNotTracked
+	}
+
+	return path, nil
+}
+
+func TestDownloadHandlerServesFormats(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	for _, file := range []string{"diagram.atxt", "diagram-scale-2_dpi-300.png"} {
+		if err := os.WriteFile(filepath.Join(root, file), []byte("output"), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/download/{name...}", NewDownloadHandler(stubOutputs{root: root}))
+
+	tests := []struct {
+		url         string
+		status      int
+		contentType string
+		disposition string
+	}{
+		{"/download/diagram?ext=txt", http.StatusOK, "text/plain; charset=utf-8", "attachment; filename=diagram.atxt"},
+		{"/download/diagram?ext=png&scale=2&dpi=300", http.StatusOK, "image/png", "attachment; filename=diagram-scale-2_dpi-300.png"},
+		{"/download/diagram?ext=png&scale=1", http.StatusNotFound, "", ""},
+		{"/download/diagram?ext=png&scale=huge", http.StatusBadRequest, "", ""},
+		{"/download/diagram?ext=svg&theme=..%2Fetc", http.StatusBadRequest, "", ""},
+		{"/download/diagram?ext=svg&theme=nosuchtheme", http.StatusBadRequest, "", ""},
+		{"/download/diagram?ext=pdf", http.StatusNotFound, "", ""},
+		{"/download/broken?ext=png", http.StatusUnprocessableEntity, "", ""},
+		{"/download/diagram?ext=json", http.StatusBadRequest, "", ""},
+		{"/download/..%2Fsecret?ext=txt", http.StatusBadRequest, "", ""},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+		if rec.Code != tt.status {
+			t.Fatalf("%s: expected status %d, got %d", tt.url, tt.status, rec.Code)
+		}
+		if tt.contentType != "" && rec.Header().Get("Content-Type") != tt.contentType {
+			t.Fatalf("%s: unexpected content type %q", tt.url, rec.Header().Get("Content-Type"))
+		}
+		if tt.disposition != "" && rec.Header().Get("Content-Disposition") != tt.disposition {
+			t.Fatalf("%s: unexpected disposition %q", tt.url, rec.Header().Get("Content-Disposition"))
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// exportMarker is written into the diagrams directory of an export, so the
+// next export knows it may replace it.
+const exportMarker = ".pumlws-export"
+
+// ErrUnsafeExport is returned when replacing the diagrams directory of the
+// destination could delete files the export didn't write.
+var ErrUnsafeExport = errors.New("refusing to replace the diagrams directory")
+
+// SiteExporter writes the diagram pages as a static site: index.html, a page
+// per diagram with its SVG inlined and the downloads next to it, and the
+// static assets. Exported pages have neither live updates nor the editor.
+type SiteExporter struct {
+	inputFolder  string
+	outputFolder string
+	templates    *template.Template
+	static       fs.FS
+	outputs      diagramOutputs
+	formats      []plantuml.Format
+}
+
+// NewSiteExporter creates an exporter for the diagrams rendered from
+// inputFolder into outputFolder. templates are cloned for every page and must
+// not be executed elsewhere. Downloads are written for formats, SVG and PNG
+// are always included since the index links them.
+func NewSiteExporter(inputFolder, outputFolder string, templates *template.Template, static fs.FS, outputs diagramOutputs, formats []string) *SiteExporter {
+	e := &SiteExporter{
+		inputFolder:  inputFolder,
+		outputFolder: outputFolder,
+		templates:    templates,
+		static:       static,
+		outputs:      outputs,
+	}
+
+	for _, name := range append([]string{"svg", "png"}, formats...) {
+		format, ok := plantuml.LookupFormat(name)
+		if ok && !slices.Contains(e.formats, format) {
+			e.formats = append(e.formats, format)
+		}
+	}
+
+	return e
+}
+
+// Export writes the site to dest and returns the number of diagram pages.
+// The diagrams directory of dest is replaced, so pages of deleted diagrams
+// don't linger, but only if an earlier export wrote it. Downloads that fail
+// to render are left out of their page.
+func (e *SiteExporter) Export(ctx context.Context, dest string) (int, error) {
+	files, err := collectSVGFiles(e.outputFolder)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("list diagrams: %w", err)
+	}
+
+	diagramsDir := filepath.Join(dest, "diagrams")
+	if err := e.checkReplaceable(diagramsDir); err != nil {
+		return 0, err
+	}
+	if err := os.RemoveAll(diagramsDir); err != nil {
+		return 0, err
+	}
+
+	// Marks the directory as written by an export before anything else
+	if err := writeSiteFile(filepath.Join(diagramsDir, exportMarker), nil); err != nil {
+		return 0, err
+	}
+
+	if err := e.writePage(dest, "index.html", "index.html", buildFileTree(files, "")); err != nil {
+		return 0, err
+	}
+
+	for _, diagram := range files {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		if err := e.exportDiagram(ctx, dest, diagram, files); err != nil {
+			return 0, fmt.Errorf("export %s: %w", diagram, err)
+		}
+	}
+
+	if err := e.copyStatic(dest); err != nil {
+		return 0, fmt.Errorf("copy static files: %w", err)
+	}
+
+	return len(files), nil
+}
+
+// checkReplaceable refuses to replace diagramsDir if it overlaps the input or
+// output folder, or holds files no export wrote.
+func (e *SiteExporter) checkReplaceable(diagramsDir string) error {
+	for _, folder := range []string{e.inputFolder, e.outputFolder} {
+		if overlaps(diagramsDir, folder) {
+			return fmt.Errorf("%w: %s overlaps %s", ErrUnsafeExport, diagramsDir, folder)
+		}
+	}
+
+	entries, err := os.ReadDir(diagramsDir)
+	if os.IsNotExist(err) || len(entries) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(filepath.Join(diagramsDir, exportMarker)); err != nil {
+		return fmt.Errorf("%w: %s was not written by an export", ErrUnsafeExport, diagramsDir)
+	}
+
+	return nil
+}
+
+// overlaps reports whether a and b are the same directory or one contains
+// the other.
+func overlaps(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	if errA != nil || errB != nil {
+		return true
+	}
+
+	return within(absA, absB) || within(absB, absA)
+}
+
+// within reports whether path is dir or below it.
+func within(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+func (e *SiteExporter) exportDiagram(ctx context.Context, dest, diagram string, files []string) error {
+	svg, err := os.ReadFile(filepath.Join(e.outputFolder, filepath.FromSlash(diagram)+".svg"))
+	if err != nil {
+		return err
+	}
+
+	data := SvgViewData{
+		Diagram: diagram,
+		Tree:    buildFileTree(files, diagram),
+		Static:  true,
+		SVG:     trustedSVG(svg),
+	}
+
+	exported := []plantuml.Format{}
+	for _, format := range e.formats {
+		target := filepath.Join(dest, filepath.FromSlash(siteDownloadPath(diagram, format.Name)))
+
+		// Broken diagrams still have the error image, show it like the live page does
+		if format.Name == "svg" {
+			if err := writeSiteFile(target, svg); err != nil {
+				return err
+			}
+			exported = append(exported, format)
+			continue
+		}
+
+		outputFile, err := e.outputs.OutputForDiagram(ctx, diagram, format.Name, plantuml.RenderOptions{})
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			log.WarnContext(ctx, "skipping download of exported diagram", "diagram", diagram, "format", format.Name, "error", err)
+			continue
+		}
+
+		content, err := os.ReadFile(outputFile)
+		if err != nil {
+			return err
+		}
+		if err := writeSiteFile(target, content); err != nil {
+			return err
+		}
+		exported = append(exported, format)
+	}
+	data.Downloads, data.MoreDownloads = splitDownloads(exported)
+
+	return e.writePage(dest, sitePagePath(diagram), "output.html", data)
+}
+
+// writePage executes the template name with links relative to page.
+func (e *SiteExporter) writePage(dest, page, name string, data any) error {
+	templates, err := e.templates.Clone()
+	if err != nil {
+		return err
+	}
+	templates.Funcs(siteLinks(page))
+
+	var rendered bytes.Buffer
+	if err := templates.ExecuteTemplate(&rendered, name, data); err != nil {
+		return fmt.Errorf("render %s: %w", page, err)
+	}
+
+	return writeSiteFile(filepath.Join(dest, filepath.FromSlash(page)), rendered.Bytes())
+}
+
+func (e *SiteExporter) copyStatic(dest string) error {
+	return fs.WalkDir(e.static, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		content, err := fs.ReadFile(e.static, name)
+		if err != nil {
+			return err
+		}
+
+		return writeSiteFile(filepath.Join(dest, "static", filepath.FromSlash(name)), content)
+	})
+}
+
+func writeSiteFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, content, 0o644)
+}
+
+// splitDownloads gives SVG and PNG their own buttons, other formats are
+// listed in a menu.
+func splitDownloads(formats []plantuml.Format) (downloads, more []plantuml.Format) {
+	for _, format := range formats {
+		if format.Name == "svg" || format.Name == "png" {
+			downloads = append(downloads, format)
+		} else {
+			more = append(more, format)
+		}
+	}
+
+	return downloads, more
+}
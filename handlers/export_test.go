@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSiteExporterWritesStaticPages(t *testing.T) {
+	t.Parallel()
+
+	outputFolder := t.TempDir()
+	files := map[string]string{
+		"top.svg":        "<svg>top</svg>",
+		"top.png":        "png top",
+		"docs/flow.svg":  "<svg>flow</svg>",
+		"broken.svg":     "<svg>error</svg>",
+		".pumlws/x.svg":  "<svg>data</svg>",
+		"docs/flow.atxt": "ascii flow",
+	}
+	for name, content := range files {
+		path := filepath.Join(outputFolder, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	templates, err := template.New("").Funcs(ServerLinks()).ParseGlob("../templates/*.html")
+	if err != nil {
+		t.Fatalf("parse templates failed: %v", err)
+	}
+	static := fstest.MapFS{"plant.ico": {Data: []byte("icon")}}
+
+	// A page of a diagram deleted since the previous export
+	dest := t.TempDir()
+	stale := filepath.Join(dest, "diagrams", "deleted.html")
+	if err := os.MkdirAll(filepath.Dir(stale), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	for _, name := range []string{stale, filepath.Join(dest, "diagrams", exportMarker)} {
+		if err := os.WriteFile(name, []byte("old"), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	exporter := NewSiteExporter(t.TempDir(), outputFolder, templates, static, stubOutputs{root: outputFolder}, []string{"svg", "txt"})
+	pages, err := exporter.Export(context.Background(), dest)
+	if err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if pages != 3 {
+		t.Fatalf("expected 3 diagram pages, got %d", pages)
+	}
+
+	read := func(name string) string {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(dest, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatalf("read %s failed: %v", name, err)
+		}
+		return string(content)
+	}
+
+	for name, want := range map[string]string{
+		"diagrams/top.svg":        "<svg>top</svg>",
+		"diagrams/top.png":        "png top",
+		"diagrams/docs/flow.atxt": "ascii flow",
+		"diagrams/broken.svg":     "<svg>error</svg>",
+		"static/plant.ico":        "icon",
+	} {
+		if got := read(name); got != want {
+			t.Fatalf("unexpected %s: %q", name, got)
+		}
+	}
+	for _, name := range []string{"diagrams/broken.png", "diagrams/deleted.html", "diagrams/.pumlws/x.html"} {
+		if _, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Fatalf("expected %s not to be exported, got %v", name, err)
+		}
+	}
+
+	index := read("index.html")
+	for _, want := range []string{
+		`href="static/plant.ico"`,
+		`href="diagrams/docs/flow.html"`,
+		`href="diagrams/top.png"`,
+	} {
+		if !strings.Contains(index, want) {
+			t.Fatalf("expected index to contain %s", want)
+		}
+	}
+
+	page := read("diagrams/docs/flow.html")
+	for _, want := range []string{
+		"<svg>flow</svg>",
+		`href="../../index.html"`,
+		`href="../../diagrams/top.html"`,
+		`href="../../diagrams/docs/flow.atxt"`,
+		"const staticPage =  true ;",
+	} {
+		if !strings.Contains(page, want) {
+			t.Fatalf("expected page to contain %s", want)
+		}
+	}
+	if strings.Contains(page, "Edit Source") || strings.Contains(page, "editor-drawer\"") {
+		t.Fatal("expected exported page without editor")
+	}
+}
+
+func TestSiteExporterKeepsFoldersItDidNotWrite(t *testing.T) {
+	t.Parallel()
+
+	templates, err := template.New("").Funcs(ServerLinks()).ParseGlob("../templates/*.html")
+	if err != nil {
+		t.Fatalf("parse templates failed: %v", err)
+	}
+
+	// export -input diagrams -dest . in a repository
+	repo := t.TempDir()
+	inputFolder := filepath.Join(repo, "diagrams")
+	outputFolder := filepath.Join(repo, "output")
+	foreign := filepath.Join(repo, "site", "diagrams", "notes.txt")
+	for _, path := range []string{filepath.Join(inputFolder, "flow.puml"), foreign} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte("keep"), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	exporter := NewSiteExporter(inputFolder, outputFolder, templates, fstest.MapFS{}, stubOutputs{root: outputFolder}, nil)
+	for _, dest := range []string{repo, filepath.Join(repo, "output", "site"), filepath.Join(repo, "site")} {
+		if _, err := exporter.Export(context.Background(), dest); !errors.Is(err, ErrUnsafeExport) {
+			t.Fatalf("expected export to %s to be refused, got %v", dest, err)
+		}
+	}
+
+	for _, path := range []string{filepath.Join(inputFolder, "flow.puml"), foreign} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s to be kept, got %v", path, err)
+		}
+	}
+
+	// An empty folder is fine to export into, and replaced the next time
+	dest := filepath.Join(repo, "public")
+	for range 2 {
+		if _, err := exporter.Export(context.Background(), dest); err != nil {
+			t.Fatalf("export failed: %v", err)
+		}
+	}
+}
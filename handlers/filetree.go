@@ -24,6 +24,11 @@ func collectSVGFiles(root string) ([]string, error) {
 			return err
 		}
 
+		// Hidden directories hold the server's own data, not diagrams
+		if info != nil && info.IsDir() && path != root && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+
 		if info == nil || info.IsDir() || !strings.HasSuffix(path, ".svg") {
 			return nil
 		}
@@ -63,6 +63,13 @@ func TestCollectSVGFilesUsesRelativeSlashSeparatedPaths(t *testing.T) {
 	if err := os.WriteFile(filepath.Join(nestedDir, "ignore.txt"), []byte("txt"), 0o644); err != nil {
 		t.Fatalf("write ignore file failed: %v", err)
 	}
+	hiddenDir := filepath.Join(root, ".pumlws")
+	if err := os.MkdirAll(hiddenDir, 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(hiddenDir, "cached.svg"), []byte("svg"), 0o644); err != nil {
+		t.Fatalf("write hidden svg failed: %v", err)
+	}
 
 	files, err := collectSVGFiles(root)
 	if err != nil {
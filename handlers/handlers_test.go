@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+)
+
+// testRenderer draws every line of the source as a text element of an SVG,
+// other formats get the format and the source. Sources containing "broken"
+// report an error on line 2 next to the output, those containing "slow"
+// render until they are cancelled.
+type testRenderer struct {
+	renders atomic.Int32
+}
+
+func (r *testRenderer) ExecuteWithFormat(ctx context.Context, input, output, format string) (string, error) {
+	r.renders.Add(1)
+
+	source, err := os.ReadFile(input)
+	if err != nil {
+		return err.Error(), err
+	}
+
+	if strings.Contains(string(source), "slow") {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+
+	content := append([]byte(format+":"), source...)
+	if format == "svg" {
+		var svg strings.Builder
+		svg.WriteString(`<svg xmlns="http://www.w3.org/2000/svg">`)
+		for i, line := range strings.Split(strings.TrimSpace(string(source)), "\n") {
+			fmt.Fprintf(&svg, `<text x="0" y="%d">%s</text>`, i*20, html.EscapeString(line))
+		}
+		svg.WriteString("</svg>")
+		content = []byte(svg.String())
+	}
+
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return err.Error(), err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(input), ".puml") + "." + plantuml.FormatExtension(format)
+	if err := os.WriteFile(filepath.Join(output, name), content, 0o644); err != nil {
+		return err.Error(), err
+	}
+
+	if strings.Contains(string(source), "broken") {
+		return "Error line 2 in file: " + input + "\nSyntax Error?", errors.New("exit status 200")
+	}
+
+	return "", nil
+}
+
+func (r *testRenderer) Version() string {
+	return "test"
+}
+
+// newTestWatcher writes sources, by path below the input folder, renders
+// them with a testRenderer and returns the watcher with its input and output
+// folders.
+func newTestWatcher(t *testing.T, sources map[string]string, opts inputwatcher.Options) (*inputwatcher.InputWatcher, string, string) {
+	t.Helper()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	for name, content := range sources {
+		path := filepath.Join(inputDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	iw := inputwatcher.New(inputDir, outputDir, &testRenderer{}, opts)
+	for _, file := range iw.GetFiles(ctx) {
+		iw.RegenerateIfNeeded(ctx, file)
+	}
+
+	return iw, inputDir, outputDir
+}
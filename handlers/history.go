@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// HistoryAPIHandler serves the render history of a diagram as JSON: GET
+// /api/diagrams/{name}/history lists the renders kept and GET
+// /api/diagrams/{name}/history?id=... returns one with its SVG and source.
+// It also serves them under /api/history/{name}, which can't be mistaken for
+// a diagram named history.
+type HistoryAPIHandler struct {
+	inputWatcher *inputwatcher.InputWatcher
+}
+
+type historyResponse struct {
+	Diagram string                 `json:"diagram"`
+	History []historyEntryResponse `json:"history"`
+}
+
+type historyEntryResponse struct {
+	ID            string    `json:"id"`
+	URL           string    `json:"url"`
+	RenderedAt    time.Time `json:"renderedAt"`
+	SourceVersion string    `json:"sourceVersion,omitempty"`
+	SVG           string    `json:"svg,omitempty"`
+	Source        string    `json:"source,omitempty"`
+}
+
+func historyEntryURL(diagram, id string) string {
+	return "/api/diagrams/" + escapePath(diagram) + "/history?id=" + url.QueryEscape(id)
+}
+
+func NewHistoryAPIHandler(inputWatcher *inputwatcher.InputWatcher) *HistoryAPIHandler {
+	return &HistoryAPIHandler{inputWatcher: inputWatcher}
+}
+
+func (h *HistoryAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.serve(w, r, path.Clean(r.PathValue("name")))
+}
+
+// serve lists the renders kept for diagram or returns the one picked by the
+// id query parameter.
+func (h *HistoryAPIHandler) serve(w http.ResponseWriter, r *http.Request, diagram string) {
+	id := r.URL.Query().Get("id")
+
+	// The history of diagrams that are gone is kept, but not served
+	if _, ok := h.inputWatcher.Diagram(diagram); !ok {
+		http.Error(w, "diagram not found", http.StatusNotFound)
+		return
+	}
+
+	history, err := h.inputWatcher.History(diagram)
+	if err != nil {
+		log.ErrorContext(r.Context(), "failed to list render history", "diagram", diagram, "error", err)
+		http.Error(w, "failed to list render history", http.StatusInternalServerError)
+		return
+	}
+
+	if id == "" {
+		response := historyResponse{Diagram: diagram, History: []historyEntryResponse{}}
+		for _, entry := range history {
+			response.History = append(response.History, newHistoryEntryResponse(diagram, entry))
+		}
+
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	for _, entry := range history {
+		if entry.ID != id {
+			continue
+		}
+
+		svg, source, err := h.inputWatcher.ReadHistory(diagram, id)
+		if errors.Is(err, inputwatcher.ErrHistoryNotFound) {
+			break
+		}
+		if err != nil {
+			log.ErrorContext(r.Context(), "failed to read render history", "diagram", diagram, "id", id, "error", err)
+			http.Error(w, "failed to read render history", http.StatusInternalServerError)
+			return
+		}
+
+		response := newHistoryEntryResponse(diagram, entry)
+		response.SVG = svg
+		response.Source = source
+		writeJSON(w, http.StatusOK, response)
+		return
+	}
+
+	http.Error(w, "history entry not found", http.StatusNotFound)
+}
+
+func newHistoryEntryResponse(diagram string, entry inputwatcher.HistoryEntry) historyEntryResponse {
+	return historyEntryResponse{
+		ID:            entry.ID,
+		URL:           historyEntryURL(diagram, entry.ID),
+		RenderedAt:    entry.RenderedAt,
+		SourceVersion: entry.SourceVersion,
+	}
+}
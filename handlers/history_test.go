@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+)
+
+func TestDiagramsAPIHandlerServesHistory(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	iw, _, _ := newTestWatcher(t, map[string]string{"docs/flow.puml": "@startuml\nA -> B\n@enduml\n"}, inputwatcher.Options{History: 5})
+	if _, _, err := iw.WriteSourceForOutput(ctx, "docs/flow", "@startuml\nA -> C\n@enduml\n", ""); err != nil {
+		t.Fatalf("WriteSourceForOutput failed: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/diagrams/{name...}", NewDiagramsAPIHandler(iw))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diagrams/docs/flow/history", nil))
+	var list historyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if rec.Code != http.StatusOK || list.Diagram != "docs/flow" || len(list.History) != 2 {
+		t.Fatalf("expected 2 renders, got %d %s", rec.Code, rec.Body.String())
+	}
+	if list.History[0].URL != "/api/diagrams/docs/flow/history?id="+list.History[0].ID || list.History[0].SVG != "" {
+		t.Fatalf("unexpected history entry %#v", list.History[0])
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, list.History[1].URL, nil))
+	var entry historyEntryResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &entry); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if rec.Code != http.StatusOK || entry.Source != "@startuml\nA -> B\n@enduml\n" || entry.SVG == "" {
+		t.Fatalf("expected the first render, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	for _, url := range []string{
+		"/api/diagrams/docs/flow/history?id=20000101T000000.000000000Z",
+		"/api/diagrams/docs/missing/history",
+	} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s, got %d", url, rec.Code)
+		}
+	}
+}
+
+func TestDiagramsAPIHandlerPrefersDiagramsNamedHistory(t *testing.T) {
+	t.Parallel()
+
+	iw, _, _ := newTestWatcher(t, map[string]string{
+		"docs.puml":         "@startuml\nA -> B\n@enduml\n",
+		"docs/history.puml": "@startuml\nA -> B\n@enduml\n",
+	}, inputwatcher.Options{History: 5})
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/diagrams/{name...}", NewDiagramsAPIHandler(iw))
+	mux.Handle("/api/history/{name...}", NewHistoryAPIHandler(iw))
+
+	// The diagram docs/history wins, the history of docs is under /api/history
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diagrams/docs/history", nil))
+	var diagram diagramResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &diagram); err != nil || diagram.Name != "docs/history" {
+		t.Fatalf("expected the diagram docs/history, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/history/docs", nil))
+	var list historyResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || list.Diagram != "docs" || len(list.History) != 1 {
+		t.Fatalf("expected the history of docs, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diagrams/docs/history/history", nil))
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || list.Diagram != "docs/history" || len(list.History) != 1 {
+		t.Fatalf("expected the history of docs/history, got %d %s", rec.Code, rec.Body.String())
+	}
+}
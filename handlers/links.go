@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"html/template"
+	"strings"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+)
+
+// ServerLinks are the template functions linking pages to the routes of the
+// server. Templates must be parsed with them.
+func ServerLinks() template.FuncMap {
+	return template.FuncMap{
+		"homeURL": func() string {
+			return "/"
+		},
+		"staticURL": func(file string) string {
+			return "/static/" + file
+		},
+		"diagramURL": func(diagram string) string {
+			return "/output/" + diagram
+		},
+		"downloadURL": func(diagram, format string) string {
+			return "/download/" + diagram + "?ext=" + format
+		},
+	}
+}
+
+// siteLinks are the template functions linking pages of an exported site to
+// each other. Links are relative to page, so the site works from any URL and
+// straight from the file system.
+func siteLinks(page string) template.FuncMap {
+	root := strings.Repeat("../", strings.Count(page, "/"))
+
+	return template.FuncMap{
+		"homeURL": func() string {
+			return root + "index.html"
+		},
+		"staticURL": func(file string) string {
+			return root + "static/" + file
+		},
+		"diagramURL": func(diagram string) string {
+			return root + sitePagePath(diagram)
+		},
+		"downloadURL": func(diagram, format string) string {
+			return root + siteDownloadPath(diagram, format)
+		},
+	}
+}
+
+func sitePagePath(diagram string) string {
+	return "diagrams/" + diagram + ".html"
+}
+
+func siteDownloadPath(diagram, format string) string {
+	return "diagrams/" + diagram + "." + plantuml.FormatExtension(format)
+}
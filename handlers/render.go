@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/mishankov/plantuml-watch-server/rendercache"
+	"github.com/mishankov/plantuml-watch-server/renderqueue"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// maxRenderSourceSize limits the diagram text accepted by the render API.
+const maxRenderSourceSize = 1 << 20
+
+var errDiagramSyntax = errors.New("diagram contains errors")
+
+var (
+	// fileBuiltins are the builtin functions reading files or the environment
+	// of the server.
+	fileBuiltins = regexp.MustCompile(`%(getenv|load_json|file_exists|dirpath|filename)\s*\(`)
+	// imageReference matches the target of an <img:...> creole image.
+	imageReference = regexp.MustCompile(`<img:([^>{]*)`)
+	// themeFromDirectory matches "!theme name from dir", which reads the
+	// theme from a local directory.
+	themeFromDirectory = regexp.MustCompile(`^\s*!theme\s+\S+\s+from\s+`)
+)
+
+// RenderHandler renders diagram text sent with the request, the way a
+// PlantUML server does: GET /{format}/{encoded} with PlantUML's text encoding
+// or POST /render?format=... with the text as body. The input folder is not
+// involved, renders share the render queue with the watcher. The renderer
+// should be sandboxed, see plantuml.NewSandboxed.
+type RenderHandler struct {
+	renderer plantuml.Renderer
+	queue    *renderqueue.Queue
+	cache    *rendercache.Cache
+	timeout  time.Duration
+}
+
+// NewRenderHandler creates the render API. cache may be nil and a zero
+// timeout disables the render timeout.
+func NewRenderHandler(renderer plantuml.Renderer, queue *renderqueue.Queue, cache *rendercache.Cache, timeout time.Duration) *RenderHandler {
+	return &RenderHandler{
+		renderer: renderer,
+		queue:    queue,
+		cache:    cache,
+		timeout:  timeout,
+	}
+}
+
+func (h *RenderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var format plantuml.Format
+	var source string
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		serverPath, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		found, ok := formatForServerPath(serverPath)
+		if !ok {
+			http.Error(w, "unsupported format: "+serverPath, http.StatusBadRequest)
+			return
+		}
+		format = found
+
+		decoded, err := plantuml.Decode(r.PathValue("encoded"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		source = decoded
+	case http.MethodPost:
+		name := r.URL.Query().Get("format")
+		if name == "" {
+			name = "svg"
+		}
+		found, ok := plantuml.LookupFormat(name)
+		if !ok {
+			http.Error(w, "unsupported format: "+name, http.StatusBadRequest)
+			return
+		}
+		format = found
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRenderSourceSize))
+		if err != nil {
+			http.Error(w, "diagram text is too large or unreadable", http.StatusBadRequest)
+			return
+		}
+		source = string(body)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	source = firstDiagram(source)
+
+	if err := checkUntrusted(source); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	image, diagnostic, err := h.render(r.Context(), source, format)
+	if errors.Is(err, context.DeadlineExceeded) {
+		http.Error(w, "diagram render timed out", http.StatusGatewayTimeout)
+		return
+	}
+	if err != nil && !errors.Is(err, errDiagramSyntax) {
+		log.ErrorContext(r.Context(), "failed to render diagram", "format", format.Name, "error", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	if err != nil {
+		// Like PlantUML servers, answer with the error image and describe the error in headers
+		w.Header().Set("X-PlantUML-Diagram-Error", strings.Join(strings.Fields(diagnostic.Message), " "))
+		w.Header().Set("X-PlantUML-Diagram-Error-Line", strconv.Itoa(diagnostic.Line))
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	w.Write(image)
+}
+
+// render renders source, which holds a single diagram, in format. Syntax
+// errors return the error image along with the first diagnostic.
+func (h *RenderHandler) render(ctx context.Context, source string, format plantuml.Format) ([]byte, plantuml.Diagnostic, error) {
+	key := rendercache.Key(h.renderer.Version(), "render api", format.Name, source)
+	if h.cache != nil {
+		if files, ok := h.cache.Get(key); ok && len(files) == 1 {
+			return files[0], plantuml.Diagnostic{}, nil
+		}
+	}
+
+	// Somebody is waiting for the response
+	release, err := h.queue.Acquire(ctx, key, renderqueue.Interactive)
+	if err != nil {
+		return nil, plantuml.Diagnostic{}, err
+	}
+	defer release()
+
+	tmpDir, err := os.MkdirTemp("", "pumlws-render-")
+	if err != nil {
+		return nil, plantuml.Diagnostic{}, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	input := filepath.Join(tmpDir, "diagram.puml")
+	if err := os.WriteFile(input, []byte(source), 0o644); err != nil {
+		return nil, plantuml.Diagnostic{}, err
+	}
+
+	renderCtx := ctx
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		renderCtx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	outputText, renderErr := h.renderer.ExecuteWithFormat(renderCtx, input, tmpDir, format.Name)
+	if renderCtx.Err() != nil {
+		return nil, plantuml.Diagnostic{}, fmt.Errorf("render: %w", renderCtx.Err())
+	}
+
+	image, err := os.ReadFile(filepath.Join(tmpDir, "diagram."+format.Extension))
+	if err != nil {
+		if renderErr != nil {
+			return nil, plantuml.Diagnostic{}, fmt.Errorf("render: %w: %s", renderErr, outputText)
+		}
+		return nil, plantuml.Diagnostic{}, err
+	}
+
+	if renderErr != nil {
+		diagnostic := plantuml.Diagnostic{Line: 1, Message: outputText}
+		if diagnostics := plantuml.ParseDiagnostics(outputText); len(diagnostics) > 0 {
+			diagnostic = diagnostics[0]
+		}
+		return image, diagnostic, errDiagramSyntax
+	}
+
+	if h.cache != nil {
+		if err := h.cache.Put(key, [][]byte{image}); err != nil {
+			log.WarnContext(ctx, "failed to store render in cache", "error", err)
+		}
+	}
+
+	return image, plantuml.Diagnostic{}, nil
+}
+
+// checkUntrusted rejects diagram text that reads files or the environment of
+// the server. The diagram did not come from the input folder, the renderer's
+// sandbox is the main protection and this is a second line of defence.
+func checkUntrusted(source string) error {
+	for _, include := range plantuml.ParseIncludes(source) {
+		if include.IsLocal() || strings.HasPrefix(strings.ToLower(include.Path), "file:") {
+			return fmt.Errorf("line %d: local includes are not supported", include.Line)
+		}
+	}
+
+	for i, line := range strings.Split(source, "\n") {
+		if match := fileBuiltins.FindStringSubmatch(line); match != nil {
+			return fmt.Errorf("line %d: %%%s is not supported", i+1, match[1])
+		}
+		if themeFromDirectory.MatchString(line) {
+			return fmt.Errorf("line %d: local themes are not supported", i+1)
+		}
+		for _, match := range imageReference.FindAllStringSubmatch(line, -1) {
+			target := strings.ToLower(strings.TrimSpace(match[1]))
+			if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+				return fmt.Errorf("line %d: local images are not supported", i+1)
+			}
+		}
+	}
+
+	return nil
+}
+
+// formatForServerPath returns the format a PlantUML server URL path renders.
+func formatForServerPath(serverPath string) (plantuml.Format, bool) {
+	for _, format := range plantuml.Formats() {
+		if format.ServerPath != "" && format.ServerPath == serverPath {
+			return format, true
+		}
+	}
+
+	return plantuml.Format{}, false
+}
+
+// firstDiagram returns the first @start/@end block of source without its
+// output name, so the render can't write anywhere else. Text without any
+// block is wrapped in @startuml/@enduml like PlantUML servers do.
+func firstDiagram(source string) string {
+	blocks := plantuml.SplitBlocks(source)
+	if len(blocks) == 0 {
+		return "@startuml\n" + strings.TrimRight(source, "\n") + "\n@enduml\n"
+	}
+
+	startLine, rest, _ := strings.Cut(blocks[0].Source, "\n")
+	tag := strings.TrimSpace(startLine)
+	if idx := strings.IndexAny(tag, " \t("); idx >= 0 {
+		tag = tag[:idx]
+	}
+
+	return tag + "\n" + rest
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/mishankov/plantuml-watch-server/rendercache"
+	"github.com/mishankov/plantuml-watch-server/renderqueue"
+)
+
+func newRenderTestServer(t *testing.T) (*http.ServeMux, *testRenderer) {
+	t.Helper()
+
+	cache, err := rendercache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("rendercache.New failed: %v", err)
+	}
+
+	renderer := &testRenderer{}
+	handler := NewRenderHandler(renderer, renderqueue.New(1), cache, 0)
+
+	mux := http.NewServeMux()
+	mux.Handle("/svg/{encoded}", handler)
+	mux.Handle("/txt/{encoded}", handler)
+	mux.Handle("/render", handler)
+
+	return mux, renderer
+}
+
+func encodeDiagram(t *testing.T, source string) string {
+	t.Helper()
+
+	encoded, err := plantuml.Encode(source)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	return encoded
+}
+
+func TestRenderHandlerRendersEncodedDiagrams(t *testing.T) {
+	t.Parallel()
+
+	mux, renderer := newRenderTestServer(t)
+	url := "/svg/" + encodeDiagram(t, "@startuml ../../escape\nA -> B\n@enduml\n")
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body)
+		}
+		if rec.Header().Get("Content-Type") != "image/svg+xml" {
+			t.Fatalf("unexpected content type %q", rec.Header().Get("Content-Type"))
+		}
+		if !strings.Contains(rec.Body.String(), "<text x=\"0\" y=\"20\">A -&gt; B</text>") {
+			t.Fatalf("unexpected body %q", rec.Body)
+		}
+	}
+
+	// The second request is served from the cache
+	if got := renderer.renders.Load(); got != 1 {
+		t.Fatalf("expected 1 render, got %d", got)
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/txt/"+encodeDiagram(t, "Bob -> Alice"), nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "txt:@startuml\nBob -> Alice\n@enduml\n" {
+		t.Fatalf("expected wrapped ASCII render, got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestRenderHandlerRendersPostedText(t *testing.T) {
+	t.Parallel()
+
+	mux, _ := newRenderTestServer(t)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/render?format=txt", strings.NewReader("@startuml\nA -> B\n@enduml\n")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("unexpected content type %q", rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestRenderHandlerReportsErrors(t *testing.T) {
+	t.Parallel()
+
+	mux, _ := newRenderTestServer(t)
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/render", strings.NewReader("@startuml\nbroken\n@enduml\n")))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", rec.Code)
+	}
+	if rec.Header().Get("X-PlantUML-Diagram-Error") != "Syntax Error?" || rec.Header().Get("X-PlantUML-Diagram-Error-Line") != "2" {
+		t.Fatalf("unexpected error headers %v", rec.Header())
+	}
+	if !strings.HasPrefix(rec.Body.String(), "<svg") {
+		t.Fatalf("expected the error image, got %q", rec.Body)
+	}
+
+	tests := []struct {
+		method string
+		url    string
+		body   string
+		status int
+	}{
+		{http.MethodGet, "/svg/not-an-encoding!", "", http.StatusBadRequest},
+		{http.MethodPost, "/render?format=gif", "A -> B", http.StatusBadRequest},
+		{http.MethodPost, "/render", "@startuml\n!include /etc/passwd\n@enduml\n", http.StatusBadRequest},
+		{http.MethodPost, "/render", "@startuml\n!include file:///etc/passwd\n@enduml\n", http.StatusBadRequest},
+		{http.MethodPost, "/render", "@startuml\nA -> B : %getenv(\"SECRET\")\n@enduml\n", http.StatusBadRequest},
+		{http.MethodPost, "/render", "@startuml\n!$data = %load_json(\"/etc/config.json\")\n@enduml\n", http.StatusBadRequest},
+		{http.MethodPost, "/render", "@startuml\nA -> B : <img:/etc/logo.png>\n@enduml\n", http.StatusBadRequest},
+		{http.MethodPost, "/render", "@startuml\n!theme dark from /etc/themes\n@enduml\n", http.StatusBadRequest},
+		{http.MethodDelete, "/render", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body)))
+		if rec.Code != tt.status {
+			t.Fatalf("%s %s: expected status %d, got %d", tt.method, tt.url, tt.status, rec.Code)
+		}
+	}
+}
+
+func TestRenderHandlerRejectsFileAccessBeforeRendering(t *testing.T) {
+	t.Parallel()
+
+	mux, renderer := newRenderTestServer(t)
+
+	for _, source := range []string{
+		"@startuml\n!include file:///etc/passwd\n@enduml\n",
+		"@startuml\nA -> B : %getenv(\"SECRET\")\n@enduml\n",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/svg/"+encodeDiagram(t, source), nil))
+		if rec.Code != http.StatusBadRequest || !strings.HasPrefix(rec.Body.String(), "line 2: ") {
+			t.Fatalf("expected %q to be rejected, got %d %q", source, rec.Code, rec.Body)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/render", strings.NewReader("@startuml\nA -> B : <img:https://example.com/logo.png>\n@enduml\n")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected images from URLs to render, got %d %q", rec.Code, rec.Body)
+	}
+	if got := renderer.renders.Load(); got != 1 {
+		t.Fatalf("expected only the allowed diagram to render, got %d renders", got)
+	}
+}
+
+func TestRenderHandlerReportsTimeouts(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.Handle("/render", NewRenderHandler(&testRenderer{}, renderqueue.New(1), nil, 10*time.Millisecond))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/render", strings.NewReader("@startuml\nslow\n@enduml\n")))
+	if rec.Code != http.StatusGatewayTimeout || !strings.Contains(rec.Body.String(), "timed out") {
+		t.Fatalf("expected a timeout, got %d %q", rec.Code, rec.Body)
+	}
+}
@@ -5,30 +5,37 @@ import (
 	"errors"
 	"net/http"
 	"path/filepath"
+	"strings"
 
 	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
 	"github.com/platforma-dev/platforma/log"
 )
 
 type SourceHandler struct {
 	inputWatcher *inputwatcher.InputWatcher
+	readOnly     bool
 }
 
 type sourceResponse struct {
-	Diagram    string `json:"diagram"`
-	SourcePath string `json:"sourcePath"`
-	Content    string `json:"content,omitempty"`
-	Saved      bool   `json:"saved,omitempty"`
-	CompileOK  bool   `json:"compileOk,omitempty"`
-	Message    string `json:"message,omitempty"`
+	Diagram     string                `json:"diagram"`
+	SourcePath  string                `json:"sourcePath"`
+	Content     string                `json:"content,omitempty"`
+	Version     string                `json:"version,omitempty"`
+	Saved       bool                  `json:"saved,omitempty"`
+	CompileOK   bool                  `json:"compileOk,omitempty"`
+	Message     string                `json:"message,omitempty"`
+	Diagnostics []plantuml.Diagnostic `json:"diagnostics,omitempty"`
 }
 
 type sourceUpdateRequest struct {
 	Content string `json:"content"`
 }
 
-func NewSourceHandler(inputWatcher *inputwatcher.InputWatcher) *SourceHandler {
-	return &SourceHandler{inputWatcher: inputWatcher}
+// NewSourceHandler serves diagram sources to the editor and saves them,
+// unless readOnly is set.
+func NewSourceHandler(inputWatcher *inputwatcher.InputWatcher, readOnly bool) *SourceHandler {
+	return &SourceHandler{inputWatcher: inputWatcher, readOnly: readOnly}
 }
 
 func (h *SourceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -36,6 +43,11 @@ func (h *SourceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		h.handleGet(w, r)
 	case http.MethodPut:
+		if h.readOnly {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "the server is read-only", http.StatusMethodNotAllowed)
+			return
+		}
 		h.handlePut(w, r)
 	default:
 		w.Header().Set("Allow", "GET, PUT")
@@ -45,22 +57,40 @@ func (h *SourceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 func (h *SourceHandler) handleGet(w http.ResponseWriter, r *http.Request) {
 	diagram := filepath.Clean(r.PathValue("name"))
-	sourcePath, content, err := h.inputWatcher.ReadSourceForOutput(diagram)
+	source, err := h.inputWatcher.ReadSourceForOutput(diagram)
 	if err != nil {
-		h.writeSourceError(w, r, diagram, err, "failed to load source")
+		writeSourceError(w, r, diagram, err, "failed to load source")
 		return
 	}
 
-	writeJSON(w, http.StatusOK, sourceResponse{
+	response := sourceResponse{
 		Diagram:    diagram,
-		SourcePath: sourcePath,
-		Content:    content,
-	})
+		SourcePath: source.Path,
+		Content:    source.Content,
+		Version:    source.Version,
+	}
+
+	// Let the editor point at the problem right away if the last render failed
+	if result, ok := h.inputWatcher.CompileResultForOutput(diagram); ok {
+		response.CompileOK = result.OK
+		response.Message = result.Message
+		response.Diagnostics = result.Diagnostics
+	}
+
+	w.Header().Set("ETag", sourceETag(source.Version))
+	writeJSON(w, http.StatusOK, response)
 }
 
 func (h *SourceHandler) handlePut(w http.ResponseWriter, r *http.Request) {
 	diagram := filepath.Clean(r.PathValue("name"))
 
+	// Saves must be based on the version the editor loaded, so changes made
+	// elsewhere in the meantime are not overwritten
+	baseVersion, ok := requireIfMatch(w, r)
+	if !ok {
+		return
+	}
+
 	var req sourceUpdateRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON payload", http.StatusBadRequest)
@@ -69,28 +99,50 @@ func (h *SourceHandler) handlePut(w http.ResponseWriter, r *http.Request) {
 
 	log.InfoContext(r.Context(), "saving diagram source", "diagram", diagram)
 
-	sourcePath, result, err := h.inputWatcher.WriteSourceForOutput(r.Context(), diagram, req.Content)
+	source, result, err := h.inputWatcher.WriteSourceForOutput(r.Context(), diagram, req.Content, baseVersion)
+	writeSaveResult(w, r, diagram, source, result, err)
+}
+
+// writeSaveResult answers a request that saved a source: with the compile
+// result, or with the current source if it changed in the meantime.
+func writeSaveResult(w http.ResponseWriter, r *http.Request, diagram string, source inputwatcher.Source, result inputwatcher.CompileResult, err error) {
+	if errors.Is(err, inputwatcher.ErrSourceConflict) {
+		log.WarnContext(r.Context(), "refused to save diagram source changed elsewhere", "diagram", diagram, "source", source.Path)
+		w.Header().Set("ETag", sourceETag(source.Version))
+		writeJSON(w, http.StatusConflict, sourceResponse{
+			Diagram:    diagram,
+			SourcePath: source.Path,
+			Content:    source.Content,
+			Version:    source.Version,
+			Message:    "the source was changed elsewhere since it was loaded",
+		})
+		return
+	}
 	if err != nil {
-		h.writeSourceError(w, r, diagram, err, "failed to save source")
+		writeSourceError(w, r, diagram, err, "failed to save source")
 		return
 	}
 
 	if result.OK {
-		log.InfoContext(r.Context(), "saved diagram source", "diagram", diagram, "source", sourcePath)
+		log.InfoContext(r.Context(), "saved diagram source", "diagram", diagram, "source", source.Path)
 	} else {
-		log.WarnContext(r.Context(), "diagram source saved with compile error", "diagram", diagram, "source", sourcePath, "message", result.Message)
+		log.WarnContext(r.Context(), "diagram source saved with compile error", "diagram", diagram, "source", source.Path, "message", result.Message)
 	}
 
+	w.Header().Set("ETag", sourceETag(source.Version))
 	writeJSON(w, http.StatusOK, sourceResponse{
-		Diagram:    diagram,
-		SourcePath: sourcePath,
-		Saved:      true,
-		CompileOK:  result.OK,
-		Message:    result.Message,
+		Diagram:     diagram,
+		SourcePath:  source.Path,
+		Content:     source.Content,
+		Version:     source.Version,
+		Saved:       true,
+		CompileOK:   result.OK,
+		Message:     result.Message,
+		Diagnostics: result.Diagnostics,
 	})
 }
 
-func (h *SourceHandler) writeSourceError(w http.ResponseWriter, r *http.Request, diagram string, err error, message string) {
+func writeSourceError(w http.ResponseWriter, r *http.Request, diagram string, err error, message string) {
 	if errors.Is(err, inputwatcher.ErrOutputNotTracked) {
 		log.WarnContext(r.Context(), message, "diagram", diagram, "error", err)
 		http.Error(w, "diagram source not found", http.StatusNotFound)
@@ -101,6 +153,37 @@ func (h *SourceHandler) writeSourceError(w http.ResponseWriter, r *http.Request,
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+func sourceETag(version string) string {
+	return `"` + version + `"`
+}
+
+// requireIfMatch returns the source version a write is based on, or answers
+// the request if it doesn't name one.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) (string, bool) {
+	baseVersion, ok := parseIfMatch(r.Header.Get("If-Match"))
+	if !ok {
+		http.Error(w, "If-Match header with the version of the source is required", http.StatusPreconditionRequired)
+	}
+
+	return baseVersion, ok
+}
+
+// parseIfMatch returns the source version of an If-Match header, empty for
+// "*" which matches any version.
+func parseIfMatch(header string) (string, bool) {
+	header = strings.TrimSpace(header)
+	if header == "*" {
+		return "", true
+	}
+
+	version := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	if version == "" {
+		return "", false
+	}
+
+	return version, true
+}
+
 func writeJSON(w http.ResponseWriter, status int, payload any) {
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+)
+
+func newSourceTestWatcher(t *testing.T, opts inputwatcher.Options) (*inputwatcher.InputWatcher, string) {
+	t.Helper()
+
+	iw, inputDir, _ := newTestWatcher(t, map[string]string{"flow.puml": "@startuml\nA -> B\n@enduml\n"}, opts)
+	return iw, filepath.Join(inputDir, "flow.puml")
+}
+
+func TestSourceHandlerReadOnly(t *testing.T) {
+	t.Parallel()
+
+	iw, source := newSourceTestWatcher(t, inputwatcher.Options{})
+	mux := http.NewServeMux()
+	mux.Handle("/source/{name...}", NewSourceHandler(iw, true))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/source/flow", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "A -\\u003e B") {
+		t.Fatalf("expected source, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/source/flow", strings.NewReader(`{"content":"@startuml\nB -> C\n@enduml\n"}`)))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET" {
+		t.Fatalf("expected PUT to be disabled, got %d", rec.Code)
+	}
+
+	content, err := os.ReadFile(source)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if !strings.Contains(string(content), "A -> B") {
+		t.Fatalf("expected source to be unchanged, got %q", content)
+	}
+}
+
+func TestSourceHandlerRequiresCurrentVersion(t *testing.T) {
+	t.Parallel()
+
+	iw, source := newSourceTestWatcher(t, inputwatcher.Options{})
+	mux := http.NewServeMux()
+	mux.Handle("/source/{name...}", NewSourceHandler(iw, false))
+
+	put := func(ifMatch, content string) (*httptest.ResponseRecorder, sourceResponse) {
+		t.Helper()
+
+		body, _ := json.Marshal(sourceUpdateRequest{Content: content})
+		r := httptest.NewRequest(http.MethodPut, "/source/flow", strings.NewReader(string(body)))
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, r)
+
+		var response sourceResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &response)
+		return rec, response
+	}
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/source/flow", nil))
+	var loaded sourceResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &loaded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if loaded.Version == "" || rec.Header().Get("ETag") != `"`+loaded.Version+`"` {
+		t.Fatalf("expected version and ETag, got %q %q", loaded.Version, rec.Header().Get("ETag"))
+	}
+
+	if rec, _ := put("", "@startuml\nB -> C\n@enduml\n"); rec.Code != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428 without If-Match, got %d", rec.Code)
+	}
+
+	rec, saved := put(rec.Header().Get("ETag"), "@startuml\nB -> C\n@enduml\n")
+	if rec.Code != http.StatusOK || !saved.Saved || saved.Version == loaded.Version {
+		t.Fatalf("expected save, got %d %#v", rec.Code, saved)
+	}
+
+	if err := os.WriteFile(source, []byte("@startuml\nIDE -> B\n@enduml\n"), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	rec, conflict := put(`"`+saved.Version+`"`, "@startuml\nB -> D\n@enduml\n")
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409, got %d", rec.Code)
+	}
+	if conflict.Content != "@startuml\nIDE -> B\n@enduml\n" || conflict.Version == saved.Version {
+		t.Fatalf("expected the current source with the conflict, got %#v", conflict)
+	}
+
+	if rec, _ := put("*", "@startuml\nB -> D\n@enduml\n"); rec.Code != http.StatusOK {
+		t.Fatalf("expected If-Match * to overwrite, got %d", rec.Code)
+	}
+}
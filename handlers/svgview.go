@@ -1,28 +1,86 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"html/template"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/mishankov/plantuml-watch-server/gitrepo"
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/platforma-dev/platforma/log"
 )
 
+// diagramRevisions renders diagrams as they were at a git revision.
+type diagramRevisions interface {
+	InGitRepository(ctx context.Context) bool
+	RenderAtRevision(ctx context.Context, diagram, rev string) (inputwatcher.Revision, []byte, error)
+}
+
 type SvgViewHandler struct {
 	outputFolder string
 	templates    *template.Template
+	formats      []plantuml.Format
+	readOnly     bool
+	revisions    diagramRevisions
 }
 
 type SvgViewData struct {
 	Diagram string
 	Tree    []*FileNode
+	// Downloads get their own buttons, MoreDownloads are listed in a menu
+	Downloads     []plantuml.Format
+	MoreDownloads []plantuml.Format
+	// Themes can be picked for downloads in the menu
+	Themes []string
+	// Static pages are exported copies: the SVG is inlined instead of
+	// streamed and the editor is left out
+	Static bool
+	SVG    template.HTML
+	// ReadOnly servers don't offer the editor
+	ReadOnly bool
+	// Revision is the git revision the inlined SVG was rendered at, Commit
+	// the abbreviated commit it refers to
+	Revision string
+	Commit   string
+	// Git offers comparing the diagram with the committed version
+	Git bool
+}
+
+// Live reports whether the page shows the current render and follows its
+// updates, rather than a fixed SVG.
+func (d SvgViewData) Live() bool {
+	return !d.Static && d.Revision == ""
+}
+
+// Editable reports whether the page offers the source editor.
+func (d SvgViewData) Editable() bool {
+	return d.Live() && !d.ReadOnly
 }
 
-func NewSvgViewHandler(outputFolder string, templates *template.Template) *SvgViewHandler {
-	return &SvgViewHandler{
+// NewSvgViewHandler serves the diagram page with download links for the
+// formats offered for download. The editor is left out if readOnly is set.
+// With ?rev=... the diagram is shown as rendered at a git revision by
+// revisions, which may be nil to disable that.
+func NewSvgViewHandler(outputFolder string, templates *template.Template, formats []string, readOnly bool, revisions diagramRevisions) *SvgViewHandler {
+	h := &SvgViewHandler{
 		outputFolder: outputFolder,
 		templates:    templates,
+		readOnly:     readOnly,
+		revisions:    revisions,
+	}
+
+	for _, name := range formats {
+		if format, ok := plantuml.LookupFormat(name); ok {
+			h.formats = append(h.formats, format)
+		}
 	}
+
+	return h
 }
 
 func (h *SvgViewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -65,11 +123,64 @@ func (h *SvgViewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := SvgViewData{
-		Diagram: svgName,
-		Tree:    buildFileTree(files, svgName),
+		Diagram:  svgName,
+		Tree:     buildFileTree(files, svgName),
+		Themes:   plantuml.Themes,
+		ReadOnly: h.readOnly,
+	}
+
+	if rev := r.URL.Query().Get("rev"); rev != "" {
+		if !h.renderRevision(w, r, &data, rev) {
+			return
+		}
+	} else {
+		data.Downloads, data.MoreDownloads = splitDownloads(h.formats)
+		data.Git = h.revisions != nil && h.revisions.InGitRepository(r.Context())
 	}
 
 	if err := renderHTMLTemplate(w, h.templates, "output.html", data); err != nil {
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 	}
 }
+
+// renderRevision inlines the diagram as rendered at rev into data. Downloads
+// are left out, they would be of the current render. It reports whether the
+// page can be shown, an error page is written otherwise.
+func (h *SvgViewHandler) renderRevision(w http.ResponseWriter, r *http.Request, data *SvgViewData, rev string) bool {
+	if h.revisions == nil {
+		renderErrorPage(w, r, h.templates, http.StatusNotFound, "Diagrams can't be shown at git revisions here.")
+		return false
+	}
+
+	revision, svg, err := h.revisions.RenderAtRevision(r.Context(), data.Diagram, rev)
+	switch {
+	case err == nil:
+	case errors.Is(err, gitrepo.ErrNotRepository):
+		renderErrorPage(w, r, h.templates, http.StatusNotFound, "The diagrams are not in a git repository.")
+		return false
+	case errors.Is(err, gitrepo.ErrRevisionNotFound):
+		renderErrorPage(w, r, h.templates, http.StatusNotFound, "The git revision "+rev+" could not be found.")
+		return false
+	case errors.Is(err, gitrepo.ErrFileNotFound), errors.Is(err, inputwatcher.ErrOutputNotTracked):
+		renderErrorPage(w, r, h.templates, http.StatusNotFound, "The diagram did not exist at "+rev+".")
+		return false
+	case errors.Is(err, inputwatcher.ErrRenderFailed):
+		renderErrorPage(w, r, h.templates, http.StatusUnprocessableEntity, "The diagram could not be rendered at "+rev+": "+err.Error())
+		return false
+	default:
+		log.ErrorContext(r.Context(), "failed to render diagram at revision", "diagram", data.Diagram, "rev", rev, "error", err)
+		renderErrorPage(w, r, h.templates, http.StatusInternalServerError, "Unable to render the diagram at "+rev+".")
+		return false
+	}
+
+	data.Revision = revision.Rev
+	data.Commit = revision.Commit[:min(len(revision.Commit), 12)]
+	data.SVG = trustedSVG(svg)
+	return true
+}
+
+// trustedSVG inlines an SVG into a page. The SVG is PlantUML's own output,
+// the live page shows it unescaped as well.
+func trustedSVG(svg []byte) template.HTML {
+	return template.HTML(svg)
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/gitrepo/gitrepotest"
+	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+)
+
+// newGitTestWatcher watches a repository with flow.puml committed as
+// "A -> B" and changed to "A -> C" in the working tree.
+func newGitTestWatcher(t *testing.T) (*inputwatcher.InputWatcher, string) {
+	t.Helper()
+
+	iw, inputDir, outputDir := newTestWatcher(t, map[string]string{"flow.puml": "@startuml\nA -> B\n@enduml\n"}, inputwatcher.Options{})
+	gitrepotest.Init(t, inputDir)
+	gitrepotest.Commit(t, inputDir, "first")
+
+	input := filepath.Join(inputDir, "flow.puml")
+	if err := os.WriteFile(input, []byte("@startuml\nA -> C\n@enduml\n"), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	iw.RegenerateIfNeeded(context.Background(), input)
+
+	return iw, outputDir
+}
+
+func TestSvgViewHandlerRendersAtRevision(t *testing.T) {
+	t.Parallel()
+
+	templates, err := template.New("").Funcs(ServerLinks()).ParseGlob("../templates/*.html")
+	if err != nil {
+		t.Fatalf("parse templates failed: %v", err)
+	}
+
+	iw, outputDir := newGitTestWatcher(t)
+	mux := http.NewServeMux()
+	mux.Handle("/output/{name...}", NewSvgViewHandler(outputDir, templates, []string{"svg", "png"}, false, iw))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/output/flow", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "beforeRev=HEAD") {
+		t.Fatalf("expected the live page to offer comparing with HEAD, got %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/output/flow?rev=HEAD", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the page at HEAD, got %d %s", rec.Code, body)
+	}
+	for _, want := range []string{"At HEAD · ", "A -&gt; B", "Compare with working tree"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in the page, got %s", want, body)
+		}
+	}
+	for _, unwanted := range []string{"A -&gt; C", `href="/download/`, `id="editor-drawer"`} {
+		if strings.Contains(body, unwanted) {
+			t.Fatalf("expected no %s in the page at HEAD, got %s", unwanted, body)
+		}
+	}
+
+	for _, target := range []string{"/output/flow?rev=missing", "/output/flow?rev=--help", "/output/missing?rev=HEAD"} {
+		rec = httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for %s, got %d", target, rec.Code)
+		}
+	}
+}
@@ -2,22 +2,69 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/mishankov/plantuml-watch-server/hub"
 	"github.com/mishankov/plantuml-watch-server/inputwatcher"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
 	"github.com/platforma-dev/platforma/log"
 )
 
+// diagramMessage is sent to live viewers over the WebSocket as JSON. Its type
+// is one of "svg" (new content), "compile" (result of a render), "deleted"
+// or "renamed" (Diagram holds the new name).
+type diagramMessage struct {
+	Type    string         `json:"type"`
+	SVG     string         `json:"svg,omitempty"`
+	Diagram string         `json:"diagram,omitempty"`
+	Compile *compileStatus `json:"compile,omitempty"`
+}
+
+type compileStatus struct {
+	OK          bool                  `json:"ok"`
+	Message     string                `json:"message,omitempty"`
+	Diagnostics []plantuml.Diagnostic `json:"diagnostics,omitempty"`
+}
+
+func newCompileStatus(result inputwatcher.CompileResult) *compileStatus {
+	return &compileStatus{
+		OK:          result.OK,
+		Message:     result.Message,
+		Diagnostics: result.Diagnostics,
+	}
+}
+
+// diagramUpdate is an encoded diagramMessage shared by every viewer of a
+// diagram. Final updates end the connection.
+type diagramUpdate struct {
+	data  []byte
+	final bool
+}
+
+const (
+	writeWait  = 10 * time.Second
+	pongWait   = 60 * time.Second
+	pingPeriod = pongWait * 9 / 10
+)
+
 type SVGWSHandler struct {
 	outputFolder string
+	inputWatcher *inputwatcher.InputWatcher
+	hub          *hub.Hub[diagramUpdate]
 }
 
-func NewSVGWSHandler(outputFolder string) *SVGWSHandler {
-	return &SVGWSHandler{outputFolder: outputFolder}
+func NewSVGWSHandler(outputFolder string, inputWatcher *inputwatcher.InputWatcher) *SVGWSHandler {
+	return &SVGWSHandler{
+		outputFolder: outputFolder,
+		inputWatcher: inputWatcher,
+		hub:          hub.New[diagramUpdate](),
+	}
 }
 
 func (h *SVGWSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -48,8 +95,7 @@ func (h *SVGWSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	svg, err := os.ReadFile(svgFullPath)
-	if err != nil {
+	if _, err := os.Stat(svgFullPath); err != nil {
 		w.WriteHeader(404)
 		w.Write([]byte("Error getting SVG: " + err.Error()))
 		return
@@ -67,31 +113,157 @@ func (h *SVGWSHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	defer ws.Close()
+
+	// Browsers answer pings on their own, a missing pong means the peer is gone
+	ws.SetReadDeadline(time.Now().Add(pongWait))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
 	go func() {
 		for {
 			if _, _, err := ws.NextReader(); err != nil {
 				log.ErrorContext(ctx, "WebSocket connection aborted", "error", err)
-				ws.Close()
 				cancel()
 				break
 			}
 		}
 	}()
 
-	ws.WriteMessage(1, svg)
+	// Subscribe before reading the SVG so no update in between is missed
+	sub := h.hub.Subscribe(filepath.ToSlash(svgName))
+	defer sub.Unsubscribe()
+
+	svg, err := os.ReadFile(svgFullPath)
+	if err != nil {
+		log.ErrorContext(ctx, "Error reading SVG", "svg", svgFullPath, "error", err)
+		return
+	}
+
+	initial, err := json.Marshal(h.svgMessage(svgName, svg))
+	if err != nil {
+		log.ErrorContext(ctx, "Error encoding message", "error", err)
+		return
+	}
+
+	if err := writeWS(ws, websocket.TextMessage, initial); err != nil {
+		log.ErrorContext(ctx, "Error writing to WebSocket", "error", err)
+		return
+	}
+
+	// Re-renders of a diagram someone is looking at go first
+	removeViewer := h.inputWatcher.AddViewer(svgName)
+	defer removeViewer()
+
+	ping := time.NewTicker(pingPeriod)
+	defer ping.Stop()
 
 	log.InfoContext(ctx, "Started watching diagram", "svg", svgFullPath)
 	for {
-		err := inputwatcher.WatchFile(ctx, svgFullPath)
-		if err != nil {
-			log.ErrorContext(ctx, "Stopped watching diagram", "svg", svgFullPath, "error", err)
-			break
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-sub.Messages():
+			if !ok {
+				log.WarnContext(ctx, "Viewer fell behind, closing WebSocket", "svg", svgFullPath)
+				return
+			}
+
+			if err := writeWS(ws, websocket.TextMessage, update.data); err != nil {
+				log.ErrorContext(ctx, "Error writing to WebSocket", "error", err)
+				return
+			}
+
+			// There is nothing left to watch under this name
+			if update.final {
+				return
+			}
+		case <-ping.C:
+			if err := writeWS(ws, websocket.PingMessage, nil); err != nil {
+				log.ErrorContext(ctx, "Error pinging WebSocket", "error", err)
+				return
+			}
 		}
+	}
+}
+
+func writeWS(ws *websocket.Conn, messageType int, data []byte) error {
+	if err := ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+
+	return ws.WriteMessage(messageType, data)
+}
+
+// Run forwards watcher events to the viewers of each diagram. A re-rendered
+// SVG is read and encoded once, however many sockets show it.
+func (h *SVGWSHandler) Run(ctx context.Context) error {
+	events, unsubscribe := h.inputWatcher.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case event, ok := <-events:
+			if !ok {
+				return nil
+			}
+
+			h.broadcast(ctx, event)
+		}
+	}
+}
+
+func (h *SVGWSHandler) broadcast(ctx context.Context, event inputwatcher.Event) {
+	if h.hub.Subscribers(event.Diagram) == 0 {
+		return
+	}
 
-		svg, _ := os.ReadFile(svgFullPath)
-		if len(svg) != 0 {
-			log.InfoContext(ctx, "SVG changed", "svg", svgFullPath)
-			ws.WriteMessage(1, svg)
+	message := eventMessage(event)
+	if event.Type == inputwatcher.EventRendered {
+		svgPath := filepath.Join(h.outputFolder, filepath.FromSlash(event.Diagram)+".svg")
+		svg, err := os.ReadFile(svgPath)
+		if err != nil {
+			log.WarnContext(ctx, "failed to read rendered SVG", "svg", svgPath, "error", err)
+			return
 		}
+
+		message = diagramMessage{Type: "svg", SVG: string(svg), Compile: newCompileStatus(event.Result)}
 	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to encode diagram message", "error", err)
+		return
+	}
+
+	final := message.Type == "deleted" || message.Type == "renamed"
+	delivered := h.hub.Publish(event.Diagram, diagramUpdate{data: data, final: final})
+	log.InfoContext(ctx, "diagram update sent", "diagram", event.Diagram, "type", message.Type, "viewers", delivered)
+}
+
+// eventMessage converts a watcher event into the message sent to viewers.
+func eventMessage(event inputwatcher.Event) diagramMessage {
+	switch event.Type {
+	case inputwatcher.EventDeleted:
+		return diagramMessage{Type: "deleted"}
+	case inputwatcher.EventRenamed:
+		return diagramMessage{Type: "renamed", Diagram: event.NewDiagram}
+	default:
+		return diagramMessage{Type: "compile", Compile: newCompileStatus(event.Result)}
+	}
+}
+
+// svgMessage wraps the SVG together with the last compile result of its
+// source, so viewers can tell an error image from a real diagram.
+func (h *SVGWSHandler) svgMessage(svgName string, svg []byte) diagramMessage {
+	message := diagramMessage{Type: "svg", SVG: string(svg)}
+
+	if result, ok := h.inputWatcher.CompileResultForOutput(svgName); ok {
+		message.Compile = newCompileStatus(result)
+	}
+
+	return message
 }
@@ -0,0 +1,100 @@
+// Package hub fans messages published once out to every subscriber of a
+// topic.
+package hub
+
+import "sync"
+
+// bufferSize is how many messages a subscriber may fall behind before it is
+// dropped.
+const bufferSize = 16
+
+type Hub[T any] struct {
+	mu     sync.Mutex
+	topics map[string]map[*Subscription[T]]struct{}
+}
+
+// Subscription receives the messages published to one topic.
+type Subscription[T any] struct {
+	hub      *Hub[T]
+	topic    string
+	messages chan T
+}
+
+func New[T any]() *Hub[T] {
+	return &Hub[T]{topics: make(map[string]map[*Subscription[T]]struct{})}
+}
+
+func (h *Hub[T]) Subscribe(topic string) *Subscription[T] {
+	sub := &Subscription[T]{hub: h, topic: topic, messages: make(chan T, bufferSize)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.topics[topic] == nil {
+		h.topics[topic] = make(map[*Subscription[T]]struct{})
+	}
+	h.topics[topic][sub] = struct{}{}
+
+	return sub
+}
+
+// Subscribers returns how many subscriptions topic has, so publishers can
+// skip preparing messages nobody receives.
+func (h *Hub[T]) Subscribers(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return len(h.topics[topic])
+}
+
+// Publish delivers message to every subscriber of topic and returns how many
+// received it. A subscriber whose buffer is full is dropped and its channel
+// closed, so its connection can start over from a fresh state instead of
+// holding up everyone else.
+func (h *Hub[T]) Publish(topic string, message T) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delivered := 0
+	for sub := range h.topics[topic] {
+		select {
+		case sub.messages <- message:
+			delivered++
+		default:
+			h.remove(sub)
+		}
+	}
+
+	return delivered
+}
+
+// remove forgets sub and closes its channel. h.mu must be held.
+func (h *Hub[T]) remove(sub *Subscription[T]) {
+	subs, ok := h.topics[sub.topic]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.topics, sub.topic)
+	}
+	close(sub.messages)
+}
+
+// Messages is closed once the subscription ends, either by Unsubscribe or
+// because the subscriber fell too far behind.
+func (s *Subscription[T]) Messages() <-chan T {
+	return s.messages
+}
+
+// Unsubscribe stops delivery. It is safe to call more than once.
+func (s *Subscription[T]) Unsubscribe() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+
+	s.hub.remove(s)
+}
@@ -0,0 +1,67 @@
+package hub
+
+import "testing"
+
+func TestPublishFansOutToTopicSubscribers(t *testing.T) {
+	h := New[string]()
+	first := h.Subscribe("a")
+	second := h.Subscribe("a")
+	other := h.Subscribe("b")
+
+	if delivered := h.Publish("a", "update"); delivered != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", delivered)
+	}
+
+	for _, sub := range []*Subscription[string]{first, second} {
+		if got := <-sub.Messages(); got != "update" {
+			t.Fatalf("unexpected message %q", got)
+		}
+	}
+
+	select {
+	case got := <-other.Messages():
+		t.Fatalf("subscriber of another topic received %q", got)
+	default:
+	}
+}
+
+func TestUnsubscribeClosesMessages(t *testing.T) {
+	h := New[string]()
+	sub := h.Subscribe("a")
+
+	sub.Unsubscribe()
+	sub.Unsubscribe()
+
+	if _, ok := <-sub.Messages(); ok {
+		t.Fatal("expected closed channel")
+	}
+	if n := h.Subscribers("a"); n != 0 {
+		t.Fatalf("expected no subscribers, got %d", n)
+	}
+	if delivered := h.Publish("a", "update"); delivered != 0 {
+		t.Fatalf("expected no deliveries, got %d", delivered)
+	}
+}
+
+func TestPublishDropsSlowSubscribers(t *testing.T) {
+	h := New[int]()
+	slow := h.Subscribe("a")
+
+	for i := range bufferSize {
+		h.Publish("a", i)
+	}
+	if delivered := h.Publish("a", bufferSize); delivered != 0 {
+		t.Fatalf("expected full subscriber to be skipped, got %d deliveries", delivered)
+	}
+
+	received := 0
+	for range slow.Messages() {
+		received++
+	}
+	if received != bufferSize {
+		t.Fatalf("expected %d buffered messages before close, got %d", bufferSize, received)
+	}
+
+	// Unsubscribing a dropped subscription must not close the channel twice
+	slow.Unsubscribe()
+}
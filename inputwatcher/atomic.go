@@ -0,0 +1,52 @@
+package inputwatcher
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writeFileAtomic replaces the content of path without ever leaving a
+// partially written file behind: the content goes to a temporary file next
+// to it, which is renamed over path once complete. Permissions and, where
+// possible, ownership of the existing file are kept. Symlinks are followed so
+// the link itself stays in place.
+func writeFileAtomic(path string, content []byte) error {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+
+	// A hidden name without the .puml extension keeps the watcher away from it
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmpName, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := copyOwner(tmpName, info); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpName, target)
+}
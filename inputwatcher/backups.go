@@ -0,0 +1,138 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// backupsDirName holds earlier versions of sources edited in the browser,
+	// inside the data directory so the watcher doesn't see them.
+	backupsDirName = "backups"
+	// defaultBackupInterval is how long a backup covers autosaves, so a
+	// burst of them doesn't push earlier versions out of the rolling set.
+	defaultBackupInterval = time.Minute
+)
+
+var ErrBackupNotFound = errors.New("backup not found")
+
+// Backup is an earlier version of a source, taken before it was overwritten
+// from the browser.
+type Backup struct {
+	ID        string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// backupStore keeps the backups of a source, one file per backup.
+func (iw *InputWatcher) backupStore(inputFile string) rollingStore {
+	return rollingStore{
+		dir:   filepath.Join(iw.outputPath, DataDirName, backupsDirName, filepath.FromSlash(iw.relativeInputPath(inputFile))),
+		exts:  []string{""},
+		limit: iw.backups,
+	}
+}
+
+// Backups returns the backups of the source of a diagram, newest first.
+func (iw *InputWatcher) Backups(outputRel string) ([]Backup, error) {
+	inputFile, err := iw.inputForDiagram(outputRel)
+	if err != nil {
+		return nil, err
+	}
+
+	return iw.listBackups(inputFile)
+}
+
+// ReadBackup returns the content of a backup of the source of a diagram.
+func (iw *InputWatcher) ReadBackup(outputRel, id string) (string, error) {
+	inputFile, err := iw.inputForDiagram(outputRel)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := iw.backupStore(inputFile).read(id, "")
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrBackupNotFound
+	}
+
+	return string(content), err
+}
+
+// RestoreBackup saves a backup as the source of a diagram, like
+// WriteSourceForOutput does with content from the editor. The version being
+// replaced is backed up in turn, so a restore can be undone.
+func (iw *InputWatcher) RestoreBackup(ctx context.Context, outputRel, id, baseVersion string) (Source, CompileResult, error) {
+	content, err := iw.ReadBackup(outputRel, id)
+	if err != nil {
+		return Source{}, CompileResult{}, err
+	}
+
+	inputFile, err := iw.inputForDiagram(outputRel)
+	if err != nil {
+		return Source{}, CompileResult{}, err
+	}
+
+	return iw.writeSource(ctx, inputFile, content, baseVersion, true)
+}
+
+func (iw *InputWatcher) inputForDiagram(outputRel string) (string, error) {
+	outputFile, err := iw.outputPathForDiagram(outputRel)
+	if err != nil {
+		return "", err
+	}
+
+	inputFile, ok := iw.ResolveInputForOutput(outputFile)
+	if !ok {
+		return "", ErrOutputNotTracked
+	}
+
+	return inputFile, nil
+}
+
+func (iw *InputWatcher) listBackups(inputFile string) ([]Backup, error) {
+	entries, err := iw.backupStore(inputFile).list()
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Backup{}
+	for _, entry := range entries {
+		backups = append(backups, Backup{ID: entry.ID, CreatedAt: entry.CreatedAt, Size: entry.Size})
+	}
+
+	return backups, nil
+}
+
+// backupSource keeps the current content of inputFile before it is
+// overwritten and drops the oldest backups beyond the configured number.
+// Unless always is set, sources saved again shortly after the last backup are
+// not backed up again.
+func (iw *InputWatcher) backupSource(inputFile string, content []byte, always bool) error {
+	if iw.backups <= 0 {
+		return nil
+	}
+
+	store := iw.backupStore(inputFile)
+	backups, err := store.list()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if store.isLatest(backups, content) {
+		return nil
+	}
+	if len(backups) > 0 && !always && now.Sub(backups[0].CreatedAt) < iw.backupInterval {
+		return nil
+	}
+
+	if err := store.add(now.Format(entryIDLayout), content); err != nil {
+		return fmt.Errorf("write backup: %w", err)
+	}
+
+	return nil
+}
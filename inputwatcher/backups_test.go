@@ -0,0 +1,155 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteSourceForOutputKeepsBackups(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	iw, inputDir, _ := newTestWatcher(t, Options{Backups: 2})
+	iw.backupInterval = 0
+	input := filepath.Join(inputDir, "flow.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	if err := os.Chmod(input, 0o600); err != nil {
+		t.Fatalf("chmod failed: %v", err)
+	}
+	iw.RegenerateIfNeeded(ctx, input)
+
+	for _, target := range []string{"C", "D", "E"} {
+		// Backups are named after the time they are taken
+		time.Sleep(time.Millisecond)
+		if _, _, err := iw.WriteSourceForOutput(ctx, "flow", "@startuml\nA -> "+target+"\n@enduml\n", ""); err != nil {
+			t.Fatalf("WriteSourceForOutput failed: %v", err)
+		}
+	}
+
+	backups, err := iw.Backups("flow")
+	if err != nil {
+		t.Fatalf("Backups failed: %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expected the 2 newest backups, got %#v", backups)
+	}
+
+	newest, err := iw.ReadBackup("flow", backups[0].ID)
+	if err != nil || !strings.Contains(newest, "A -> D") {
+		t.Fatalf("expected the newest backup to hold the previous version, got %q %v", newest, err)
+	}
+	oldest, err := iw.ReadBackup("flow", backups[1].ID)
+	if err != nil || !strings.Contains(oldest, "A -> C") {
+		t.Fatalf("expected the oldest backup to be A -> C, got %q %v", oldest, err)
+	}
+
+	if _, err := iw.ReadBackup("flow", "../../flow.puml"); !errors.Is(err, ErrBackupNotFound) {
+		t.Fatalf("expected invalid id to be rejected, got %v", err)
+	}
+
+	info, err := os.Stat(input)
+	if err != nil {
+		t.Fatalf("stat failed: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected permissions to be kept, got %v", info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(inputDir)
+	if err != nil {
+		t.Fatalf("read dir failed: %v", err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Fatalf("expected no temporary files to be left, got %s", entry.Name())
+		}
+	}
+}
+
+func TestGetFilesSkipsOutputInsideInputFolder(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	inputDir := t.TempDir()
+	iw := New(inputDir, filepath.Join(inputDir, "out"), fakeRenderer{}, Options{Backups: 2})
+	iw.backupInterval = 0
+	input := filepath.Join(inputDir, "flow.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.RegenerateIfNeeded(ctx, input)
+
+	if _, _, err := iw.WriteSourceForOutput(ctx, "flow", "@startuml\nA -> C\n@enduml\n", ""); err != nil {
+		t.Fatalf("WriteSourceForOutput failed: %v", err)
+	}
+	if backups, err := iw.Backups("flow"); err != nil || len(backups) != 1 {
+		t.Fatalf("expected a backup, got %#v %v", backups, err)
+	}
+
+	hidden := filepath.Join(inputDir, ".drafts")
+	if err := os.Mkdir(hidden, 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	writeInput(t, filepath.Join(hidden, "draft.puml"), "@startuml\nA -> D\n@enduml\n")
+
+	if files := iw.GetFiles(ctx); len(files) != 1 || files[0] != input {
+		t.Fatalf("expected only the source to be listed, got %v", files)
+	}
+}
+
+func TestRestoreBackupBacksUpReplacedSource(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	iw, inputDir, _ := newTestWatcher(t, Options{Backups: 5})
+	input := filepath.Join(inputDir, "flow.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.RegenerateIfNeeded(ctx, input)
+
+	saved, _, err := iw.WriteSourceForOutput(ctx, "flow", "@startuml\nA -> C\n@enduml\n", "")
+	if err != nil {
+		t.Fatalf("WriteSourceForOutput failed: %v", err)
+	}
+	// Shortly after the last backup, autosaves are not backed up again
+	time.Sleep(time.Millisecond)
+	saved, _, err = iw.WriteSourceForOutput(ctx, "flow", "@startuml\nA -> D\n@enduml\n", saved.Version)
+	if err != nil {
+		t.Fatalf("WriteSourceForOutput failed: %v", err)
+	}
+
+	backups, err := iw.Backups("flow")
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expected a single backup, got %#v %v", backups, err)
+	}
+
+	if _, _, err := iw.RestoreBackup(ctx, "flow", backups[0].ID, "stale"); !errors.Is(err, ErrSourceConflict) {
+		t.Fatalf("expected conflict for an outdated version, got %v", err)
+	}
+
+	time.Sleep(time.Millisecond)
+	restored, result, err := iw.RestoreBackup(ctx, "flow", backups[0].ID, saved.Version)
+	if err != nil || !result.OK {
+		t.Fatalf("RestoreBackup failed: %v %#v", err, result)
+	}
+	if !strings.Contains(restored.Content, "A -> B") {
+		t.Fatalf("expected the restored source, got %q", restored.Content)
+	}
+
+	// The restore itself can be undone
+	backups, err = iw.Backups("flow")
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("expected the replaced source to be backed up, got %#v %v", backups, err)
+	}
+	replaced, err := iw.ReadBackup("flow", backups[0].ID)
+	if err != nil || !strings.Contains(replaced, "A -> D") {
+		t.Fatalf("expected backup of A -> D, got %q %v", replaced, err)
+	}
+
+	if _, _, err := iw.RestoreBackup(ctx, "flow", "20000101T000000.000000000Z", ""); !errors.Is(err, ErrBackupNotFound) {
+		t.Fatalf("expected missing backup, got %v", err)
+	}
+}
@@ -0,0 +1,59 @@
+package inputwatcher
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// BuildResult is the outcome of one source file in Build.
+type BuildResult struct {
+	// Source is relative to the input folder.
+	Source   string
+	Diagrams []string
+	Result   CompileResult
+	// Reused reports that the outputs of a previous run were up to date.
+	Reused   bool
+	Duration time.Duration
+}
+
+// Build renders every source in the input folder once, reusing outputs of a
+// previous run that are still up to date, deletes outputs no source generates
+// anymore and saves the state for the next run. Results are sorted by source.
+func (iw *InputWatcher) Build(ctx context.Context) ([]BuildResult, error) {
+	if err := iw.LoadState(ctx); err != nil {
+		return nil, err
+	}
+
+	started := time.Now()
+	compiled := iw.generateAll(ctx)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := iw.saveState(); err != nil {
+		return nil, fmt.Errorf("save state: %w", err)
+	}
+
+	results := make([]BuildResult, 0, len(compiled))
+	for inputFile, result := range compiled {
+		iw.compileMutex.RLock()
+		tracked := iw.compileCache[inputFile]
+		iw.compileMutex.RUnlock()
+
+		results = append(results, BuildResult{
+			Source:   iw.relativeInputPath(inputFile),
+			Diagrams: iw.diagramsForInput(inputFile),
+			Result:   result,
+			Reused:   result.OK && tracked.RenderedAt.Before(started),
+			Duration: tracked.Duration,
+		})
+	}
+	slices.SortFunc(results, func(a, b BuildResult) int {
+		return strings.Compare(a.Source, b.Source)
+	})
+
+	return results, nil
+}
@@ -0,0 +1,50 @@
+package inputwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildReportsResultsAndReusesOutputs(t *testing.T) {
+	t.Parallel()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	writeInput(t, filepath.Join(inputDir, "good.puml"), "@startuml\nA -> B\n@enduml\n")
+	writeInput(t, filepath.Join(inputDir, "bad.puml"), "@startuml\nbroken\n@enduml\n")
+	orphan := filepath.Join(outputDir, "orphan.svg")
+	writeInput(t, orphan, "<svg/>")
+
+	results, err := New(inputDir, outputDir, fakeRenderer{}, Options{}).Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	if len(results) != 2 || results[0].Source != "bad.puml" || results[1].Source != "good.puml" {
+		t.Fatalf("unexpected results %#v", results)
+	}
+	if results[0].Result.OK || len(results[0].Result.Diagnostics) == 0 {
+		t.Fatalf("expected bad.puml to fail with diagnostics, got %#v", results[0].Result)
+	}
+	if !results[1].Result.OK || results[1].Reused || len(results[1].Diagrams) != 1 || results[1].Diagrams[0] != "good" {
+		t.Fatalf("expected good.puml to render, got %#v", results[1])
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("expected orphan to be deleted, got %v", err)
+	}
+
+	// A second build only renders what failed or changed
+	renderer := &countingRenderer{}
+	results, err = New(inputDir, outputDir, renderer, Options{}).Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if !results[1].Reused {
+		t.Fatalf("expected good.puml to be reused, got %#v", results[1])
+	}
+	if got := renderer.renders.Load(); got != 1 {
+		t.Fatalf("expected only bad.puml to render again, got %d renders", got)
+	}
+}
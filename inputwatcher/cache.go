@@ -0,0 +1,88 @@
+package inputwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/mishankov/plantuml-watch-server/rendercache"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// cacheKey describes a render of inputFile in format with opts for the render
+// cache and returns the output files, in block order, the render writes.
+func (iw *InputWatcher) cacheKey(inputFile, format string, opts plantuml.RenderOptions) (string, []string, bool) {
+	if iw.cache == nil {
+		return "", nil, false
+	}
+
+	source, err := os.ReadFile(inputFile)
+	if err != nil {
+		return "", nil, false
+	}
+
+	// Multi-page diagrams write extra files that are not known per block
+	blocks := plantuml.SplitBlocks(string(source))
+	if len(blocks) == 0 || strings.Contains(string(source), "newpage") {
+		return "", nil, false
+	}
+
+	hash, err := iw.sourceHash(inputFile)
+	if err != nil {
+		return "", nil, false
+	}
+
+	key := rendercache.Key(iw.pulm.Version(), format, hash)
+	if !opts.IsZero() {
+		key = rendercache.Key(iw.pulm.Version(), format, opts.Key(), hash)
+	}
+	return key, plantuml.OutputFileNames(inputFile, blocks, format), true
+}
+
+// restoreCached writes the cached outputs of key into outputDir.
+func (iw *InputWatcher) restoreCached(ctx context.Context, key, outputDir string, names []string) bool {
+	files, ok := iw.cache.Get(key)
+	if !ok || len(files) != len(names) {
+		return false
+	}
+
+	for i, name := range names {
+		path := filepath.Join(outputDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			log.WarnContext(ctx, "failed to restore cached render", "file", path, "error", err)
+			return false
+		}
+
+		if err := os.WriteFile(path, files[i], 0o644); err != nil {
+			log.WarnContext(ctx, "failed to restore cached render", "file", path, "error", err)
+			return false
+		}
+	}
+
+	log.InfoContext(ctx, "restored render from cache", "outputs", names)
+	return true
+}
+
+// storeCached adds the outputs of a successful render to the cache.
+func (iw *InputWatcher) storeCached(ctx context.Context, key, inputFile, outputDir, format string, opts plantuml.RenderOptions, names []string) {
+	// The source may have changed while rendering, the outputs then belong to another key
+	if current, _, ok := iw.cacheKey(inputFile, format, opts); !ok || current != key {
+		return
+	}
+
+	files := make([][]byte, 0, len(names))
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(outputDir, name))
+		if err != nil {
+			// PlantUML named the output differently than expected
+			return
+		}
+		files = append(files, data)
+	}
+
+	if err := iw.cache.Put(key, files); err != nil {
+		log.WarnContext(ctx, "failed to store render in cache", "input", inputFile, "error", err)
+	}
+}
@@ -0,0 +1,52 @@
+package inputwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/rendercache"
+)
+
+func TestRenderServesIdenticalContentFromCache(t *testing.T) {
+	t.Parallel()
+
+	cache, err := rendercache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("rendercache.New failed: %v", err)
+	}
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	renderer := &countingRenderer{}
+	iw := New(inputDir, outputDir, renderer, Options{Cache: cache})
+
+	source := "@startuml\nA -> B\n@enduml\n"
+	original := filepath.Join(inputDir, "original.puml")
+	copied := filepath.Join(inputDir, "copy.puml")
+	writeInput(t, original, source)
+	writeInput(t, copied, source)
+
+	if result := iw.RegenerateIfNeeded(context.Background(), original); !result.OK {
+		t.Fatalf("render failed: %#v", result)
+	}
+	rendered := renderer.renders.Load()
+
+	if result := iw.RegenerateIfNeeded(context.Background(), copied); !result.OK {
+		t.Fatalf("cached render failed: %#v", result)
+	}
+	if got := renderer.renders.Load(); got != rendered {
+		t.Fatalf("expected identical content to be served from cache, renders went from %d to %d", rendered, got)
+	}
+
+	// Unnamed blocks are named after the file they come from
+	svg, err := os.ReadFile(filepath.Join(outputDir, "copy.svg"))
+	if err != nil || !strings.Contains(string(svg), "A -> B") {
+		t.Fatalf("expected cached output under the copy's name, got %q (err %v)", svg, err)
+	}
+	if _, ok := iw.CompileResultForOutput("copy"); !ok {
+		t.Fatal("expected cached output to be tracked")
+	}
+}
@@ -0,0 +1,144 @@
+package inputwatcher
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/platforma-dev/platforma/log"
+)
+
+// EventType tells what happened to a diagram.
+type EventType string
+
+const (
+	// EventRendered is published after a diagram rendered successfully.
+	EventRendered EventType = "rendered"
+	// EventFailed is published after a render of a diagram's source failed.
+	EventFailed EventType = "failed"
+	// EventDeleted is published after a diagram's outputs were removed.
+	EventDeleted EventType = "deleted"
+	// EventRenamed is published when a diagram moved to a new name.
+	EventRenamed EventType = "renamed"
+)
+
+// eventBuffer is how many events a slow subscriber may fall behind before
+// events are dropped for it.
+const eventBuffer = 64
+
+// Event describes a change of a diagram, identified by its output path
+// relative to the output folder without the .svg extension.
+type Event struct {
+	Type    EventType
+	Diagram string
+	// NewDiagram is the new name of a renamed diagram.
+	NewDiagram string
+	// Result is the compile result for rendered and failed events.
+	Result CompileResult
+}
+
+type subscribers struct {
+	mu       sync.Mutex
+	channels []chan Event
+}
+
+// Subscribe returns a channel receiving every published event. The returned
+// function unsubscribes and closes the channel.
+func (iw *InputWatcher) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+
+	iw.subscribers.mu.Lock()
+	iw.subscribers.channels = append(iw.subscribers.channels, ch)
+	iw.subscribers.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			iw.subscribers.mu.Lock()
+			defer iw.subscribers.mu.Unlock()
+
+			iw.subscribers.channels = slices.DeleteFunc(iw.subscribers.channels, func(other chan Event) bool { return other == ch })
+			close(ch)
+		})
+	}
+}
+
+func (iw *InputWatcher) publish(ctx context.Context, event Event) {
+	iw.subscribers.mu.Lock()
+	defer iw.subscribers.mu.Unlock()
+
+	for _, ch := range iw.subscribers.channels {
+		select {
+		case ch <- event:
+		default:
+			log.WarnContext(ctx, "dropping diagram event for slow subscriber", "diagram", event.Diagram, "type", event.Type)
+		}
+	}
+}
+
+// publishResult tells subscribers about the outcome of rendering inputFile.
+func (iw *InputWatcher) publishResult(ctx context.Context, inputFile string, result CompileResult) {
+	eventType := EventRendered
+	if !result.OK {
+		eventType = EventFailed
+	}
+
+	for _, diagram := range iw.diagramsForInput(inputFile) {
+		iw.publish(ctx, Event{Type: eventType, Diagram: diagram, Result: result})
+	}
+}
+
+// diagramsForInput returns the diagrams currently generated by inputFile.
+func (iw *InputWatcher) diagramsForInput(inputFile string) []string {
+	iw.fileToSvgMutex.RLock()
+	defer iw.fileToSvgMutex.RUnlock()
+
+	return iw.diagramNames(iw.fileToSvgMap[inputFile])
+}
+
+// diagramNames converts tracked output files to sorted diagram names.
+func (iw *InputWatcher) diagramNames(outputs map[string]bool) []string {
+	diagrams := []string{}
+	for outputFile := range outputs {
+		if diagram, ok := iw.diagramName(outputFile); ok {
+			diagrams = append(diagrams, diagram)
+		}
+	}
+	slices.Sort(diagrams)
+
+	return diagrams
+}
+
+// diagramName returns the diagram name of an SVG output file.
+func (iw *InputWatcher) diagramName(outputFile string) (string, bool) {
+	if !strings.HasSuffix(outputFile, ".svg") {
+		return "", false
+	}
+
+	relPath, err := filepath.Rel(iw.outputPath, outputFile)
+	if err != nil {
+		return "", false
+	}
+
+	// Variants in the data directory are not diagrams of their own
+	if strings.HasPrefix(relPath, DataDirName+string(filepath.Separator)) {
+		return "", false
+	}
+
+	return filepath.ToSlash(strings.TrimSuffix(relPath, ".svg")), true
+}
+
+// publishMoves reports diagrams that disappeared. When exactly one diagram
+// went away and exactly one appeared it is reported as renamed instead.
+func (iw *InputWatcher) publishMoves(ctx context.Context, removed, added []string) {
+	if len(removed) == 1 && len(added) == 1 {
+		iw.publish(ctx, Event{Type: EventRenamed, Diagram: removed[0], NewDiagram: added[0]})
+		return
+	}
+
+	for _, diagram := range removed {
+		iw.publish(ctx, Event{Type: EventDeleted, Diagram: diagram})
+	}
+}
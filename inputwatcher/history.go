@@ -0,0 +1,143 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/platforma-dev/platforma/log"
+)
+
+const (
+	// historyDirName holds earlier renders of every diagram, inside the data
+	// directory so they are not listed as diagrams.
+	historyDirName = "history"
+	// historySourceExt is the extension of the source kept next to every SVG
+	// in the history. It isn't .puml so sources are never rendered.
+	historySourceExt = ".source"
+)
+
+var ErrHistoryNotFound = errors.New("history entry not found")
+
+// HistoryEntry is an earlier successful render of a diagram.
+type HistoryEntry struct {
+	ID         string
+	RenderedAt time.Time
+	// SourceVersion identifies the source the SVG was rendered from, like
+	// Source.Version does.
+	SourceVersion string
+}
+
+// historyStore keeps the history of a diagram, an SVG and its source per
+// render.
+func (iw *InputWatcher) historyStore(diagram string) (rollingStore, error) {
+	// Reject names outside the output folder
+	if _, err := iw.outputPathForDiagram(diagram); err != nil {
+		return rollingStore{}, err
+	}
+
+	return rollingStore{
+		dir:   filepath.Join(iw.outputPath, DataDirName, historyDirName, filepath.Clean(filepath.FromSlash(diagram))),
+		exts:  []string{".svg", historySourceExt},
+		limit: iw.history,
+	}, nil
+}
+
+// History returns the renders kept for a diagram, newest first. The newest
+// entry is the current render.
+func (iw *InputWatcher) History(diagram string) ([]HistoryEntry, error) {
+	store, err := iw.historyStore(diagram)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := store.list()
+	if err != nil {
+		return nil, err
+	}
+
+	history := []HistoryEntry{}
+	for _, entry := range entries {
+		historyEntry := HistoryEntry{ID: entry.ID, RenderedAt: entry.CreatedAt}
+		if source, err := store.read(entry.ID, historySourceExt); err == nil {
+			historyEntry.SourceVersion = sourceContentVersion(source)
+		}
+		history = append(history, historyEntry)
+	}
+
+	return history, nil
+}
+
+// ReadHistory returns the SVG and the source of a render kept for a diagram.
+func (iw *InputWatcher) ReadHistory(diagram, id string) (svg, source string, err error) {
+	store, err := iw.historyStore(diagram)
+	if err != nil {
+		return "", "", err
+	}
+
+	svgContent, err := store.read(id, ".svg")
+	if errors.Is(err, os.ErrNotExist) {
+		return "", "", ErrHistoryNotFound
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	sourceContent, err := store.read(id, historySourceExt)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", "", err
+	}
+
+	return string(svgContent), string(sourceContent), nil
+}
+
+// recordHistory adds the SVGs rendered from inputFile to the history of
+// their diagrams, unless they didn't change since the last render, and drops
+// the oldest entries beyond the configured number.
+func (iw *InputWatcher) recordHistory(ctx context.Context, inputFile string, svgs map[string]bool) {
+	if iw.history <= 0 {
+		return
+	}
+
+	source, err := os.ReadFile(inputFile)
+	if err != nil {
+		log.WarnContext(ctx, "failed to read source for render history", "input", inputFile, "error", err)
+		return
+	}
+
+	id := time.Now().UTC().Format(entryIDLayout)
+	for _, diagram := range iw.diagramNames(svgs) {
+		if err := iw.addHistoryEntry(diagram, id, source); err != nil {
+			log.WarnContext(ctx, "failed to record render history", "diagram", diagram, "error", err)
+		}
+	}
+}
+
+func (iw *InputWatcher) addHistoryEntry(diagram, id string, source []byte) error {
+	svg, err := os.ReadFile(filepath.Join(iw.outputPath, filepath.FromSlash(diagram)+".svg"))
+	if err != nil {
+		return err
+	}
+
+	store, err := iw.historyStore(diagram)
+	if err != nil {
+		return err
+	}
+
+	history, err := store.list()
+	if err != nil {
+		return err
+	}
+	if store.isLatest(history, svg) {
+		return nil
+	}
+
+	if err := store.add(id, svg, source); err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenderHistoryKeepsLatestRenders(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	iw, inputDir, _ := newTestWatcher(t, Options{History: 2})
+	input := filepath.Join(inputDir, "flow.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.RegenerateIfNeeded(ctx, input)
+
+	for _, source := range []string{
+		"@startuml\nA -> C\n@enduml\n",
+		// Failed renders are not kept
+		"@startuml\nbroken\n@enduml\n",
+		"@startuml\nA -> D\n@enduml\n",
+		// Neither are renders that didn't change the SVG
+		"@startuml\nA -> D\n@enduml\n",
+	} {
+		// History entries are named after the time they are taken
+		time.Sleep(time.Millisecond)
+		if _, _, err := iw.WriteSourceForOutput(ctx, "flow", source, ""); err != nil {
+			t.Fatalf("WriteSourceForOutput failed: %v", err)
+		}
+	}
+
+	history, err := iw.History("flow")
+	if err != nil {
+		t.Fatalf("History failed: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("expected the 2 newest renders, got %#v", history)
+	}
+
+	svg, source, err := iw.ReadHistory("flow", history[0].ID)
+	if err != nil {
+		t.Fatalf("ReadHistory failed: %v", err)
+	}
+	if svg != "<svg>@startuml\nA -> D\n@enduml\n" || source != "@startuml\nA -> D\n@enduml\n" {
+		t.Fatalf("expected the current render first, got %q %q", svg, source)
+	}
+	if history[0].SourceVersion != sourceContentVersion([]byte(source)) {
+		t.Fatalf("expected source version of the render, got %q", history[0].SourceVersion)
+	}
+
+	svg, _, err = iw.ReadHistory("flow", history[1].ID)
+	if err != nil || svg != "<svg>@startuml\nA -> C\n@enduml\n" {
+		t.Fatalf("expected the previous render, got %q %v", svg, err)
+	}
+
+	if _, _, err := iw.ReadHistory("flow", "../flow"); !errors.Is(err, ErrHistoryNotFound) {
+		t.Fatalf("expected invalid id to be rejected, got %v", err)
+	}
+	if _, err := iw.History("../outside"); !errors.Is(err, ErrOutputNotTracked) {
+		t.Fatalf("expected names outside the output folder to be rejected, got %v", err)
+	}
+}
+
+func TestRenderHistoryDisabled(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	iw, inputDir, _ := newTestWatcher(t, Options{})
+	input := filepath.Join(inputDir, "flow.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.RegenerateIfNeeded(ctx, input)
+
+	history, err := iw.History("flow")
+	if err != nil || len(history) != 0 {
+		t.Fatalf("expected no history, got %#v %v", history, err)
+	}
+}
@@ -0,0 +1,212 @@
+package inputwatcher
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+)
+
+// includeGraph records which local files every source includes, so a change
+// to a shared fragment can be traced back to the diagrams using it.
+type includeGraph struct {
+	mu       sync.RWMutex
+	includes map[string][]string
+	// updated is signalled whenever the graph changes
+	updated chan struct{}
+}
+
+func newIncludeGraph() *includeGraph {
+	return &includeGraph{
+		includes: make(map[string][]string),
+		updated:  make(chan struct{}, 1),
+	}
+}
+
+func (g *includeGraph) set(file string, includes []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.includes[file] = includes
+	g.signal()
+}
+
+func (g *includeGraph) forget(file string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	delete(g.includes, file)
+	g.signal()
+}
+
+// signal notifies a waiting reader of updated without blocking.
+func (g *includeGraph) signal() {
+	select {
+	case g.updated <- struct{}{}:
+	default:
+	}
+}
+
+// closure returns every file file includes, directly or transitively.
+func (g *includeGraph) closure(file string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	return g.walk(file, g.includes)
+}
+
+// dependents returns every file including file, directly or transitively.
+func (g *includeGraph) dependents(file string) []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	reverse := make(map[string][]string)
+	for includer, includes := range g.includes {
+		for _, included := range includes {
+			reverse[included] = append(reverse[included], includer)
+		}
+	}
+
+	return g.walk(file, reverse)
+}
+
+// walk collects the nodes reachable from file, excluding file itself. g.mu
+// must be held.
+func (g *includeGraph) walk(file string, edges map[string][]string) []string {
+	seen := map[string]bool{file: true}
+	queue := []string{file}
+	reached := []string{}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, next := range edges[current] {
+			if seen[next] {
+				continue
+			}
+			seen[next] = true
+			reached = append(reached, next)
+			queue = append(queue, next)
+		}
+	}
+	slices.Sort(reached)
+
+	return reached
+}
+
+// included returns every file some source includes.
+func (g *includeGraph) included() []string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	files := []string{}
+	for _, includes := range g.includes {
+		for _, included := range includes {
+			if !slices.Contains(files, included) {
+				files = append(files, included)
+			}
+		}
+	}
+	slices.Sort(files)
+
+	return files
+}
+
+// isIncluded reports whether some source includes file.
+func (g *includeGraph) isIncluded(file string) bool {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for _, includes := range g.includes {
+		if slices.Contains(includes, file) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scanIncludes re-reads file and everything it includes, transitively, and
+// records their !include, !include_many, !includesub and !import directives.
+func (iw *InputWatcher) scanIncludes(file string) {
+	visited := map[string]bool{}
+
+	var scan func(path string)
+	scan = func(path string) {
+		if visited[path] {
+			return
+		}
+		visited[path] = true
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			// Keep the file in the graph so it is noticed when it comes back
+			iw.includes.set(path, nil)
+			return
+		}
+
+		includes := []string{}
+		for _, inc := range plantuml.ParseIncludes(string(content)) {
+			resolved, ok := plantuml.ResolveInclude(filepath.Dir(path), inc)
+			if ok && !slices.Contains(includes, resolved) {
+				includes = append(includes, resolved)
+			}
+		}
+		iw.includes.set(path, includes)
+
+		for _, included := range includes {
+			scan(included)
+		}
+	}
+
+	scan(file)
+}
+
+// sourceVersion is the newest modification time of inputFile and everything
+// it includes, so editing a shared fragment counts as a change of every
+// diagram using it.
+func (iw *InputWatcher) sourceVersion(inputFile string) (time.Time, error) {
+	info, err := os.Stat(inputFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	iw.scanIncludes(inputFile)
+
+	version := info.ModTime()
+	for _, included := range iw.includes.closure(inputFile) {
+		if info, err := os.Stat(included); err == nil && info.ModTime().After(version) {
+			version = info.ModTime()
+		}
+	}
+
+	return version, nil
+}
+
+// dependentsOf returns the files including any of the changed paths or a
+// file below a changed directory.
+func (iw *InputWatcher) dependentsOf(changed map[string]bool) []string {
+	dependents := []string{}
+	for _, included := range iw.includes.included() {
+		if !changedUnder(changed, included) {
+			continue
+		}
+
+		for _, dependent := range iw.includes.dependents(included) {
+			if !slices.Contains(dependents, dependent) {
+				dependents = append(dependents, dependent)
+			}
+		}
+	}
+
+	return dependents
+}
+
+// isSourcePath reports whether a change of path may affect a diagram.
+func (iw *InputWatcher) isSourcePath(path string) bool {
+	return strings.HasSuffix(path, ".puml") || iw.includes.isIncluded(path)
+}
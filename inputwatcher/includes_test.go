@@ -0,0 +1,167 @@
+package inputwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestScanIncludesTracksTransitiveDependents(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, _ := newTestWatcher(t, Options{})
+	if err := os.MkdirAll(filepath.Join(inputDir, "shared"), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+
+	main := filepath.Join(inputDir, "main.puml")
+	styles := filepath.Join(inputDir, "_styles.puml")
+	colors := filepath.Join(inputDir, "shared", "_colors.iuml")
+	writeInput(t, main, "@startuml\n!include _styles.puml\n!include <C4/C4_Container>\nA -> B\n@enduml\n")
+	writeInput(t, styles, "!includesub shared/_colors.iuml!DARK\n")
+	writeInput(t, colors, "!startsub DARK\nskinparam backgroundColor black\n!endsub\n")
+
+	iw.scanIncludes(main)
+
+	if got := iw.includes.closure(main); !reflect.DeepEqual(got, []string{styles, colors}) {
+		t.Fatalf("unexpected includes of main: %v", got)
+	}
+	if got := iw.includes.dependents(colors); !reflect.DeepEqual(got, []string{styles, main}) {
+		t.Fatalf("unexpected dependents of colors: %v", got)
+	}
+	if !iw.isSourcePath(colors) {
+		t.Fatal("expected included .iuml file to be watched")
+	}
+}
+
+func TestApplyChangesRerendersDependents(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, _ := newTestWatcher(t, Options{})
+	main := filepath.Join(inputDir, "main.puml")
+	styles := filepath.Join(inputDir, "_styles.puml")
+	writeInput(t, main, "@startuml\n!include _styles.puml\nA -> B\n@enduml\n")
+	writeInput(t, styles, "skinparam monochrome true\n")
+
+	if result := iw.RegenerateIfNeeded(context.Background(), main); !result.OK {
+		t.Fatalf("initial render failed: %#v", result)
+	}
+
+	events, unsubscribe := iw.Subscribe()
+	defer unsubscribe()
+
+	writeInput(t, styles, "skinparam monochrome false\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(styles, later, later); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+
+	known := map[string]bool{main: true}
+	iw.applyChanges(context.Background(), known, map[string]bool{styles: true})
+
+	select {
+	case event := <-events:
+		if event.Type != EventRendered || event.Diagram != "main" {
+			t.Fatalf("unexpected event %#v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dependent diagram was not re-rendered")
+	}
+
+	if len(known) != 1 {
+		t.Fatalf("include must not be tracked as a diagram, got %v", known)
+	}
+}
+
+func TestRunRerendersDependentsOfIncludesOutsideInputFolder(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	inputDir := filepath.Join(root, "input")
+	sharedDir := filepath.Join(root, "shared")
+	for _, dir := range []string{inputDir, sharedDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+	}
+	main := filepath.Join(inputDir, "main.puml")
+	styles := filepath.Join(sharedDir, "_styles.puml")
+	writeInput(t, main, "@startuml\n!include ../shared/_styles.puml\nA -> B\n@enduml\n")
+	writeInput(t, styles, "skinparam monochrome true\n")
+
+	outputDir := t.TempDir()
+	iw := New(inputDir, outputDir, fakeRenderer{}, Options{Watch: WatchNotify, Debounce: 20 * time.Millisecond})
+
+	runWatcher(t, iw)
+	waitForFile(t, filepath.Join(outputDir, "main.svg"), "A -> B")
+
+	events, unsubscribe := iw.Subscribe()
+	defer unsubscribe()
+
+	// The directory is watched once the include was scanned, touch the
+	// include until the change comes through
+	deadline := time.After(5 * time.Second)
+	for tick := time.Now(); ; tick = tick.Add(time.Second) {
+		writeInput(t, styles, "skinparam monochrome false\n")
+		if err := os.Chtimes(styles, tick.Add(time.Second), tick.Add(time.Second)); err != nil {
+			t.Fatalf("chtimes failed: %v", err)
+		}
+
+		select {
+		case event := <-events:
+			if event.Type != EventRendered || event.Diagram != "main" {
+				t.Fatalf("unexpected event %#v", event)
+			}
+			return
+		case <-time.After(200 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("dependent diagram was not re-rendered")
+		}
+	}
+}
+
+func TestWatchIncludeDirsFollowsIncludeGraph(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	inputDir := filepath.Join(root, "input")
+	sharedDir := filepath.Join(root, "shared")
+	for _, dir := range []string{filepath.Join(inputDir, "local"), sharedDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir failed: %v", err)
+		}
+	}
+	main := filepath.Join(inputDir, "main.puml")
+	writeInput(t, main, "@startuml\n!include ../shared/_styles.puml\n!include local/_colors.puml\n@enduml\n")
+	writeInput(t, filepath.Join(sharedDir, "_styles.puml"), "skinparam monochrome true\n")
+	writeInput(t, filepath.Join(inputDir, "local", "_colors.puml"), "skinparam backgroundColor black\n")
+
+	iw := New(inputDir, t.TempDir(), fakeRenderer{}, Options{})
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatalf("NewWatcher failed: %v", err)
+	}
+	defer watcher.Close()
+
+	ctx := context.Background()
+	watching := map[string]bool{}
+	iw.scanIncludes(main)
+	iw.watchIncludeDirs(ctx, watcher, watching)
+	if !reflect.DeepEqual(watching, map[string]bool{sharedDir: true}) || !slices.Equal(watcher.WatchList(), []string{sharedDir}) {
+		t.Fatalf("expected only the shared directory to be watched, got %v %v", watching, watcher.WatchList())
+	}
+
+	// The include is dropped from the graph along with its directory
+	writeInput(t, main, "@startuml\n!include local/_colors.puml\n@enduml\n")
+	iw.scanIncludes(main)
+	iw.watchIncludeDirs(ctx, watcher, watching)
+	if len(watching) != 0 || len(watcher.WatchList()) != 0 {
+		t.Fatalf("expected the shared directory to be dropped, got %v %v", watching, watcher.WatchList())
+	}
+}
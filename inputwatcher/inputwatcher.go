@@ -3,77 +3,153 @@ package inputwatcher
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
-	"slices"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/mishankov/plantuml-watch-server/gitrepo"
 	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/mishankov/plantuml-watch-server/rendercache"
+	"github.com/mishankov/plantuml-watch-server/renderqueue"
 	"github.com/platforma-dev/platforma/log"
 )
 
-var ErrOutputNotTracked = errors.New("output file is not tracked")
+var (
+	ErrOutputNotTracked = errors.New("output file is not tracked")
+	ErrRenderTimeout    = errors.New("render timed out")
+	ErrRenderFailed     = errors.New("render failed")
+	ErrSourceConflict   = errors.New("source changed since the version the write is based on")
+)
 
 type CompileResult struct {
-	OK      bool
-	Message string
+	OK          bool
+	Message     string
+	Diagnostics []plantuml.Diagnostic
 }
 
 type trackedGeneration struct {
 	ModTime time.Time
-	Result  CompileResult
+	// Hash fingerprints the source content the result was rendered from
+	Hash   string
+	Result CompileResult
+	// RenderedAt and Duration describe the render that produced Result
+	RenderedAt time.Time
+	Duration   time.Duration
 }
 
-func WatchFile(ctx context.Context, filePath string) error {
-	initialStat, err := os.Stat(filePath)
-	if err != nil {
-		return err
-	}
-
-	for {
-		stat, err := os.Stat(filePath)
-		if err != nil {
-			return err
-		}
-
-		if stat.Size() != initialStat.Size() || stat.ModTime() != initialStat.ModTime() {
-			break
-		}
-
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(100 * time.Millisecond):
-		}
-	}
+// Options tune how an InputWatcher renders diagrams.
+type Options struct {
+	// Queue limits concurrent renders. Defaults to one slot per CPU.
+	Queue *renderqueue.Queue
+	// RenderTimeout stops a single render that runs longer. Zero disables it.
+	RenderTimeout time.Duration
+	// Cache stores rendered outputs by content. Nil disables caching.
+	Cache *rendercache.Cache
+	// Watch selects how changes are detected. Defaults to WatchAuto.
+	Watch WatchMode
+	// PollInterval is how often the input folder is scanned when polling.
+	PollInterval time.Duration
+	// Debounce is how long changes must settle before they are rendered.
+	Debounce time.Duration
+	// Backups is how many earlier versions of every source edited in the
+	// browser are kept. Zero disables backups.
+	Backups int
+	// History is how many successful renders of every diagram are kept.
+	// Zero disables the history.
+	History int
+}
 
-	return nil
+// inflightRender is a render of a source file that can be cancelled once the
+// file changes again.
+type inflightRender struct {
+	modTime time.Time
+	cancel  context.CancelFunc
 }
 
 type InputWatcher struct {
-	inputPath  string
-	outputPath string
-	pulm       *plantuml.PlantUML
-	// Maps .puml file path to the set of output files (.svg and .png) it generated
+	inputPath      string
+	outputPath     string
+	pulm           plantuml.Renderer
+	queue          *renderqueue.Queue
+	renderTimeout  time.Duration
+	cache          *rendercache.Cache
+	watchMode      WatchMode
+	pollInterval   time.Duration
+	debounce       time.Duration
+	backups        int
+	backupInterval time.Duration
+	history        int
+	// Maps .puml file path to the set of output files (.svg, .png, ...) it generated
 	fileToSvgMap   map[string]map[string]bool
 	fileToSvgMutex sync.RWMutex
 	compileCache   map[string]trackedGeneration
 	compileMutex   sync.RWMutex
 	fileLocks      map[string]*sync.Mutex
 	fileLocksMutex sync.Mutex
+	// Counts live viewers per .puml file so their renders jump the queue
+	viewers       map[string]int
+	viewersMutex  sync.Mutex
+	inflight      map[string]inflightRender
+	inflightMutex sync.Mutex
+	subscribers   subscribers
+	includes      *includeGraph
+	stateChanged  chan struct{}
+	stateMutex    sync.Mutex
+	// background holds the goroutines of Run writing to the output folder,
+	// they are waited for before the state is saved on shutdown
+	background sync.WaitGroup
+	// repo is the git repository of the input folder, opened on first use
+	repoOnce sync.Once
+	repo     *gitrepo.Repo
+	repoErr  error
 }
 
-func New(inputPath, outputPath string, pulm *plantuml.PlantUML) *InputWatcher {
+func New(inputPath, outputPath string, pulm plantuml.Renderer, opts Options) *InputWatcher {
+	queue := opts.Queue
+	if queue == nil {
+		queue = renderqueue.New(runtime.NumCPU())
+	}
+
+	watchMode := opts.Watch
+	if watchMode == "" {
+		watchMode = WatchAuto
+	}
+
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	debounce := opts.Debounce
+	if debounce <= 0 {
+		debounce = defaultDebounce
+	}
+
 	return &InputWatcher{
-		inputPath:    inputPath,
-		outputPath:   outputPath,
-		pulm:         pulm,
-		fileToSvgMap: make(map[string]map[string]bool),
-		compileCache: make(map[string]trackedGeneration),
-		fileLocks:    make(map[string]*sync.Mutex),
+		inputPath:      inputPath,
+		outputPath:     outputPath,
+		pulm:           pulm,
+		queue:          queue,
+		renderTimeout:  opts.RenderTimeout,
+		cache:          opts.Cache,
+		watchMode:      watchMode,
+		pollInterval:   pollInterval,
+		debounce:       debounce,
+		backups:        opts.Backups,
+		backupInterval: defaultBackupInterval,
+		history:        opts.History,
+		fileToSvgMap:   make(map[string]map[string]bool),
+		compileCache:   make(map[string]trackedGeneration),
+		fileLocks:      make(map[string]*sync.Mutex),
+		viewers:        make(map[string]int),
+		inflight:       make(map[string]inflightRender),
+		includes:       newIncludeGraph(),
+		stateChanged:   make(chan struct{}, 1),
 	}
 }
 
@@ -92,14 +168,17 @@ func (iw *InputWatcher) calculateOutputDir(ctx context.Context, inputFilePath st
 	return filepath.Join(iw.outputPath, relDir)
 }
 
-// getSvgFilesInDir returns a map of all output files (.svg and .png) in the given directory and its subdirectories
+// getSvgFilesInDir returns a map of all output files (.svg, .png, .pdf, ...) in the given directory and its subdirectories
 func (iw *InputWatcher) getSvgFilesInDir(ctx context.Context, dir string) map[string]bool {
 	outputFiles := make(map[string]bool)
 	err := filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			return nil // Skip directories we can't access
 		}
-		if !info.IsDir() && (strings.HasSuffix(path, ".svg") || strings.HasSuffix(path, ".png")) {
+		if info.IsDir() && isHiddenDir(dir, path) {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && isOutputFile(path) {
 			outputFiles[path] = true
 		}
 		return nil
@@ -166,14 +245,41 @@ func (iw *InputWatcher) ResolveInputForOutput(outputFile string) (string, bool)
 	return "", false
 }
 
-func (iw *InputWatcher) setCompileResult(inputFile string, modTime time.Time, result CompileResult) {
+func (iw *InputWatcher) setCompileResult(inputFile string, tracked trackedGeneration) {
+	iw.compileMutex.Lock()
+	iw.compileCache[inputFile] = tracked
+	iw.compileMutex.Unlock()
+
+	iw.markStateChanged()
+}
+
+// forgetCompileResult makes the next RegenerateIfNeeded render inputFile even
+// if its version did not change, e.g. because an included file was deleted.
+func (iw *InputWatcher) forgetCompileResult(inputFile string) {
 	iw.compileMutex.Lock()
 	defer iw.compileMutex.Unlock()
 
-	iw.compileCache[inputFile] = trackedGeneration{
-		ModTime: modTime,
-		Result:  result,
+	delete(iw.compileCache, inputFile)
+}
+
+// CompileResultForOutput returns the last compile result of the source that
+// generates the diagram.
+func (iw *InputWatcher) CompileResultForOutput(outputRel string) (CompileResult, bool) {
+	outputFile, err := iw.outputPathForDiagram(outputRel)
+	if err != nil {
+		return CompileResult{}, false
+	}
+
+	inputFile, ok := iw.ResolveInputForOutput(outputFile)
+	if !ok {
+		return CompileResult{}, false
 	}
+
+	iw.compileMutex.RLock()
+	defer iw.compileMutex.RUnlock()
+
+	tracked, ok := iw.compileCache[inputFile]
+	return tracked.Result, ok
 }
 
 func (iw *InputWatcher) cachedCompileResult(inputFile string, modTime time.Time) (CompileResult, bool) {
@@ -192,60 +298,233 @@ func (iw *InputWatcher) cachedCompileResult(inputFile string, modTime time.Time)
 	return CompileResult{}, false
 }
 
-// ExecuteAndTrack executes PlantUML for a file and tracks which SVGs were generated.
-func (iw *InputWatcher) ExecuteAndTrack(ctx context.Context, inputFile, outputDir string) CompileResult {
-	// Get SVG files before execution
-	svgsBefore := iw.getSvgFilesInDir(ctx, outputDir)
-
-	// Get modification times of existing SVGs
-	mtimesBefore := make(map[string]time.Time)
-	for svgPath := range svgsBefore {
-		if info, err := os.Stat(svgPath); err == nil {
-			mtimesBefore[svgPath] = info.ModTime()
+// AddViewer marks the diagram as being watched so renders of its source are
+// prioritized. The returned function removes the viewer again.
+func (iw *InputWatcher) AddViewer(outputRel string) func() {
+	outputFile, err := iw.outputPathForDiagram(outputRel)
+	if err != nil {
+		return func() {}
+	}
+
+	inputFile, ok := iw.ResolveInputForOutput(outputFile)
+	if !ok {
+		return func() {}
+	}
+
+	return iw.addInputViewer(inputFile)
+}
+
+// addInputViewer counts a viewer of inputFile until the returned function is
+// called.
+func (iw *InputWatcher) addInputViewer(inputFile string) func() {
+	iw.viewersMutex.Lock()
+	iw.viewers[inputFile]++
+	iw.viewersMutex.Unlock()
+
+	iw.queue.Promote(inputFile, renderqueue.Interactive)
+
+	return func() {
+		iw.viewersMutex.Lock()
+		defer iw.viewersMutex.Unlock()
+
+		iw.viewers[inputFile]--
+		if iw.viewers[inputFile] <= 0 {
+			delete(iw.viewers, inputFile)
 		}
 	}
+}
 
-	outputText, err := iw.pulm.ExecuteWithFormat(ctx, inputFile, outputDir, "svg")
-	if err != nil {
-		return CompileResult{
-			OK:      false,
-			Message: outputText,
+func (iw *InputWatcher) renderPriority(inputFile string) renderqueue.Priority {
+	iw.viewersMutex.Lock()
+	defer iw.viewersMutex.Unlock()
+
+	if iw.viewers[inputFile] > 0 {
+		return renderqueue.Interactive
+	}
+
+	return renderqueue.Background
+}
+
+// render renders inputFile with opts applied, restoring the outputs from the
+// render cache when the same input was rendered before.
+func (iw *InputWatcher) render(ctx context.Context, inputFile, outputDir, format string, opts plantuml.RenderOptions) (string, error) {
+	key, names, cacheable := iw.cacheKey(inputFile, format, opts)
+	if cacheable && iw.restoreCached(ctx, key, outputDir, names) {
+		return "", nil
+	}
+
+	sourceFile := inputFile
+	if !opts.IsZero() {
+		variantFile, cleanup, err := iw.writeVariantSource(inputFile, opts)
+		if err != nil {
+			return err.Error(), err
 		}
+		defer cleanup()
+		sourceFile = variantFile
 	}
 
-	if _, err := iw.pulm.ExecuteWithFormat(ctx, inputFile, outputDir, "png"); err != nil {
-		log.WarnContext(ctx, "png generation failed after successful svg generation", "input", inputFile, "error", err)
+	outputText, err := iw.renderWithRenderer(ctx, inputFile, sourceFile, outputDir, format)
+	if err == nil && cacheable {
+		iw.storeCached(ctx, key, inputFile, outputDir, format, opts, names)
 	}
 
-	// Get SVG files after execution
-	svgsAfter := iw.getSvgFilesInDir(ctx, outputDir)
+	return outputText, err
+}
 
-	// Determine which SVGs were created or modified by this execution
-	generatedSvgs := make(map[string]bool)
-	for svgPath := range svgsAfter {
-		// New file or modified file
-		if !svgsBefore[svgPath] {
-			generatedSvgs[svgPath] = true
-		} else if info, err := os.Stat(svgPath); err == nil {
-			if beforeTime, exists := mtimesBefore[svgPath]; exists {
-				if info.ModTime().After(beforeTime) {
-					generatedSvgs[svgPath] = true
-				}
+// renderWithRenderer runs the renderer on sourceFile, the content of
+// inputFile to render, once a render slot is available. The render timeout
+// starts when the slot is acquired, not while waiting for it.
+func (iw *InputWatcher) renderWithRenderer(ctx context.Context, inputFile, sourceFile, outputDir, format string) (string, error) {
+	release, err := iw.queue.Acquire(ctx, inputFile, iw.renderPriority(inputFile))
+	if err != nil {
+		return err.Error(), err
+	}
+	defer release()
+
+	if iw.renderTimeout <= 0 {
+		return iw.pulm.ExecuteWithFormat(ctx, sourceFile, outputDir, format)
+	}
+
+	renderCtx, cancel := context.WithTimeout(ctx, iw.renderTimeout)
+	defer cancel()
+
+	outputText, err := iw.pulm.ExecuteWithFormat(renderCtx, sourceFile, outputDir, format)
+	if err != nil && ctx.Err() == nil && errors.Is(renderCtx.Err(), context.DeadlineExceeded) {
+		log.WarnContext(ctx, "render timed out", "input", inputFile, "format", format, "timeout", iw.renderTimeout)
+		return fmt.Sprintf("Rendering timed out after %s", iw.renderTimeout), ErrRenderTimeout
+	}
+
+	return outputText, err
+}
+
+// cancelSuperseded cancels a running render of an older version of
+// inputFile, so only the newest content ends up being rendered.
+func (iw *InputWatcher) cancelSuperseded(ctx context.Context, inputFile string, modTime time.Time) {
+	iw.inflightMutex.Lock()
+	defer iw.inflightMutex.Unlock()
+
+	if running, ok := iw.inflight[inputFile]; ok && running.modTime.Before(modTime) {
+		log.InfoContext(ctx, "cancelling superseded render", "input", inputFile)
+		running.cancel()
+	}
+}
+
+// beginRender registers a cancellable render of inputFile at modTime. The
+// returned function must be called once the render is done.
+func (iw *InputWatcher) beginRender(ctx context.Context, inputFile string, modTime time.Time) (context.Context, func()) {
+	renderCtx, cancel := context.WithCancel(ctx)
+
+	iw.inflightMutex.Lock()
+	iw.inflight[inputFile] = inflightRender{modTime: modTime, cancel: cancel}
+	iw.inflightMutex.Unlock()
+
+	return renderCtx, func() {
+		cancel()
+
+		iw.inflightMutex.Lock()
+		defer iw.inflightMutex.Unlock()
+		if running, ok := iw.inflight[inputFile]; ok && running.modTime.Equal(modTime) {
+			delete(iw.inflight, inputFile)
+		}
+	}
+}
+
+// diagnostics parses renderer output into diagnostics with paths relative to
+// the input folder and the offending source lines attached.
+func (iw *InputWatcher) diagnostics(inputFile, outputText string) []plantuml.Diagnostic {
+	diagnostics := plantuml.ParseDiagnostics(outputText)
+	sources := make(map[string][]string)
+
+	for i, diagnostic := range diagnostics {
+		file := inputFile
+		if diagnostic.File != "" {
+			file = diagnostic.File
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(iw.inputPath, file)
+			}
+		}
+		diagnostics[i].File = iw.relativeInputPath(file)
+
+		if diagnostic.Line <= 0 {
+			continue
+		}
+
+		lines, ok := sources[file]
+		if !ok {
+			if content, err := os.ReadFile(file); err == nil {
+				lines = strings.Split(strings.ReplaceAll(string(content), "\r\n", "\n"), "\n")
 			}
+			sources[file] = lines
+		}
+		if diagnostic.Line <= len(lines) {
+			diagnostics[i].Context = strings.TrimRight(lines[diagnostic.Line-1], " \t")
 		}
 	}
 
-	// If no output files were detected as generated, fall back to expected naming
-	if len(generatedSvgs) == 0 {
-		// Assume the output files have the same base name as the .puml file
-		baseName := strings.TrimSuffix(filepath.Base(inputFile), ".puml")
-		expectedSvg := filepath.Join(outputDir, baseName+".svg")
-		expectedPng := filepath.Join(outputDir, baseName+".png")
-		if _, err := os.Stat(expectedSvg); err == nil {
-			generatedSvgs[expectedSvg] = true
+	return diagnostics
+}
+
+// outputModTimes returns the modification times of the output files in dir.
+func (iw *InputWatcher) outputModTimes(ctx context.Context, dir string) map[string]time.Time {
+	mtimes := make(map[string]time.Time)
+	for path := range iw.getSvgFilesInDir(ctx, dir) {
+		if info, err := os.Stat(path); err == nil {
+			mtimes[path] = info.ModTime()
+		}
+	}
+
+	return mtimes
+}
+
+// changedOutputs returns the output files with extension ext in dir that were
+// created or modified since mtimesBefore was taken.
+func (iw *InputWatcher) changedOutputs(ctx context.Context, dir, ext string, mtimesBefore map[string]time.Time) map[string]bool {
+	changed := make(map[string]bool)
+	for path := range iw.getSvgFilesInDir(ctx, dir) {
+		if filepath.Ext(path) != ext {
+			continue
+		}
+
+		beforeTime, existed := mtimesBefore[path]
+		if !existed {
+			changed[path] = true
+		} else if info, err := os.Stat(path); err == nil && info.ModTime().After(beforeTime) {
+			changed[path] = true
+		}
+	}
+
+	return changed
+}
+
+// ExecuteAndTrack executes PlantUML for a file and tracks which SVGs were
+// generated. Outputs in other formats belong to the previous version of the
+// source and are deleted, they are rendered again when requested.
+func (iw *InputWatcher) ExecuteAndTrack(ctx context.Context, inputFile, outputDir string) CompileResult {
+	mtimesBefore := iw.outputModTimes(ctx, outputDir)
+
+	outputText, err := iw.render(ctx, inputFile, outputDir, "svg", plantuml.RenderOptions{})
+	if err != nil {
+		// Errors such as a missing java binary leave no renderer output
+		if outputText == "" {
+			outputText = err.Error()
+		}
+
+		return CompileResult{
+			OK:          false,
+			Message:     outputText,
+			Diagnostics: iw.diagnostics(inputFile, outputText),
 		}
-		if _, err := os.Stat(expectedPng); err == nil {
-			generatedSvgs[expectedPng] = true
+	}
+
+	// Determine which SVGs were created or modified by this execution
+	generatedSvgs := iw.changedOutputs(ctx, outputDir, ".svg", mtimesBefore)
+
+	// If no SVG files were detected as generated, fall back to expected naming
+	if len(generatedSvgs) == 0 {
+		// Assume the SVG file has the same base name as the .puml file
+		expected := filepath.Join(outputDir, strings.TrimSuffix(filepath.Base(inputFile), ".puml")+".svg")
+		if _, err := os.Stat(expected); err == nil {
+			generatedSvgs[expected] = true
 		}
 	}
 
@@ -254,6 +533,23 @@ func (iw *InputWatcher) ExecuteAndTrack(ctx context.Context, inputFile, outputDi
 	oldSvgs := iw.fileToSvgMap[inputFile]
 	iw.fileToSvgMutex.RUnlock()
 
+	// Tell viewers of outputs that are no longer generated where they went
+	if len(oldSvgs) > 0 {
+		removed := map[string]bool{}
+		added := map[string]bool{}
+		for oldSvg := range oldSvgs {
+			if !generatedSvgs[oldSvg] {
+				removed[oldSvg] = true
+			}
+		}
+		for newSvg := range generatedSvgs {
+			if !oldSvgs[newSvg] {
+				added[newSvg] = true
+			}
+		}
+		iw.publishMoves(ctx, iw.diagramNames(removed), iw.diagramNames(added))
+	}
+
 	// Delete output files that are no longer generated
 	for oldSvg := range oldSvgs {
 		if !generatedSvgs[oldSvg] {
@@ -272,11 +568,13 @@ func (iw *InputWatcher) ExecuteAndTrack(ctx context.Context, inputFile, outputDi
 	iw.fileToSvgMap[inputFile] = generatedSvgs
 	iw.fileToSvgMutex.Unlock()
 
+	iw.recordHistory(ctx, inputFile, generatedSvgs)
+
 	return CompileResult{OK: true}
 }
 
 func (iw *InputWatcher) RegenerateIfNeeded(ctx context.Context, inputFile string) CompileResult {
-	info, err := os.Stat(inputFile)
+	version, err := iw.sourceVersion(inputFile)
 	if err != nil {
 		log.ErrorContext(ctx, "failed to stat input file before regeneration", "input", inputFile, "error", err)
 		return CompileResult{
@@ -285,16 +583,18 @@ func (iw *InputWatcher) RegenerateIfNeeded(ctx context.Context, inputFile string
 		}
 	}
 
-	if cached, ok := iw.cachedCompileResult(inputFile, info.ModTime()); ok {
+	if cached, ok := iw.cachedCompileResult(inputFile, version); ok {
 		log.InfoContext(ctx, "skipping duplicate compile for unchanged file", "input", inputFile)
 		return cached
 	}
 
+	iw.cancelSuperseded(ctx, inputFile, version)
+
 	lock := iw.getFileLock(inputFile)
 	lock.Lock()
 	defer lock.Unlock()
 
-	info, err = os.Stat(inputFile)
+	version, err = iw.sourceVersion(inputFile)
 	if err != nil {
 		log.ErrorContext(ctx, "failed to stat input file during regeneration", "input", inputFile, "error", err)
 		return CompileResult{
@@ -303,75 +603,82 @@ func (iw *InputWatcher) RegenerateIfNeeded(ctx context.Context, inputFile string
 		}
 	}
 
-	if cached, ok := iw.cachedCompileResult(inputFile, info.ModTime()); ok {
+	if cached, ok := iw.cachedCompileResult(inputFile, version); ok {
 		log.InfoContext(ctx, "skipping duplicate compile after waiting for file lock", "input", inputFile)
 		return cached
 	}
 
-	outputDir := iw.calculateOutputDir(ctx, inputFile)
-	result := iw.ExecuteAndTrack(ctx, inputFile, outputDir)
-	iw.setCompileResult(inputFile, info.ModTime(), result)
-	return result
-}
-
-func (iw *InputWatcher) ReadSourceForOutput(outputRel string) (string, string, error) {
-	outputFile, err := iw.outputPathForDiagram(outputRel)
+	// Hash before rendering, a change during the render only causes a re-render
+	hash, err := iw.sourceHash(inputFile)
 	if err != nil {
-		return "", "", err
-	}
-
-	inputFile, ok := iw.ResolveInputForOutput(outputFile)
-	if !ok {
-		return "", "", ErrOutputNotTracked
+		return CompileResult{
+			OK:      false,
+			Message: err.Error(),
+		}
 	}
 
-	content, err := os.ReadFile(inputFile)
-	if err != nil {
-		return "", "", err
-	}
+	renderCtx, done := iw.beginRender(ctx, inputFile, version)
+	defer done()
 
-	return iw.relativeInputPath(inputFile), string(content), nil
+	started := time.Now()
+	outputDir := iw.calculateOutputDir(ctx, inputFile)
+	result := iw.ExecuteAndTrack(renderCtx, inputFile, outputDir)
+	if renderCtx.Err() != nil && ctx.Err() == nil {
+		log.InfoContext(ctx, "render superseded by a newer change", "input", inputFile)
+		return result
+	}
+
+	iw.setCompileResult(inputFile, trackedGeneration{
+		ModTime:    version,
+		Hash:       hash,
+		Result:     result,
+		RenderedAt: time.Now(),
+		Duration:   time.Since(started),
+	})
+	iw.publishResult(ctx, inputFile, result)
+	return result
 }
 
-func (iw *InputWatcher) WriteSourceForOutput(ctx context.Context, outputRel string, content string) (string, CompileResult, error) {
-	outputFile, err := iw.outputPathForDiagram(outputRel)
-	if err != nil {
-		return "", CompileResult{}, err
-	}
-
-	inputFile, ok := iw.ResolveInputForOutput(outputFile)
-	if !ok {
-		return "", CompileResult{}, ErrOutputNotTracked
-	}
-
-	fileInfo, err := os.Stat(inputFile)
-	if err != nil {
-		return "", CompileResult{}, err
-	}
-
-	lock := iw.getFileLock(inputFile)
-	lock.Lock()
-	defer lock.Unlock()
+// removeOutputs deletes every output generated by a removed source file and
+// returns the diagrams that went away.
+func (iw *InputWatcher) removeOutputs(ctx context.Context, inputFile string) []string {
+	iw.fileToSvgMutex.Lock()
+	svgs, exists := iw.fileToSvgMap[inputFile]
+	delete(iw.fileToSvgMap, inputFile)
+	iw.fileToSvgMutex.Unlock()
+	iw.forgetCompileResult(inputFile)
 
-	if err := os.WriteFile(inputFile, []byte(content), fileInfo.Mode().Perm()); err != nil {
-		return "", CompileResult{}, err
+	if !iw.includes.isIncluded(inputFile) {
+		iw.includes.forget(inputFile)
 	}
 
-	info, err := os.Stat(inputFile)
-	if err != nil {
-		return "", CompileResult{}, err
+	if !exists {
+		return nil
 	}
 
-	result := iw.ExecuteAndTrack(ctx, inputFile, iw.calculateOutputDir(ctx, inputFile))
-	iw.setCompileResult(inputFile, info.ModTime(), result)
+	iw.deleteOutputs(ctx, svgs)
+	iw.markStateChanged()
 
-	return iw.relativeInputPath(inputFile), result, nil
+	return iw.diagramNames(svgs)
 }
 
 func (iw *InputWatcher) GetFiles(ctx context.Context) []string {
 	files := []string{}
 	err := filepath.Walk(iw.inputPath, func(path string, info fs.FileInfo, err error) error {
-		if strings.HasSuffix(path, ".puml") {
+		if err != nil {
+			return nil
+		}
+
+		// The output folder may live inside the input folder, its renders
+		// and the backups in its data directory are not sources
+		if info.IsDir() {
+			if path != iw.inputPath && (path == iw.outputPath || strings.HasPrefix(info.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.Mode().IsRegular() && strings.HasSuffix(path, ".puml") {
 			// Skip files prefixed with underscore
 			if !strings.HasPrefix(filepath.Base(path), "_") {
 				files = append(files, path)
@@ -387,69 +694,3 @@ func (iw *InputWatcher) GetFiles(ctx context.Context) []string {
 
 	return files
 }
-
-func (iw *InputWatcher) Run(ctx context.Context) error {
-	files := iw.GetFiles(ctx)
-	oldFiles := []string{}
-
-	for {
-		for _, file := range files {
-			if !slices.Contains(oldFiles, file) {
-				log.InfoContext(ctx, "watching new file", "file", file)
-				iw.RegenerateIfNeeded(ctx, file)
-
-				go func(watchedFile string) {
-					for {
-						err := WatchFile(ctx, watchedFile)
-						if err != nil {
-							log.ErrorContext(ctx, "stopped watching file", "error", err)
-							break
-						}
-
-						log.InfoContext(ctx, "file changed", "file", watchedFile)
-
-						iw.RegenerateIfNeeded(ctx, watchedFile)
-					}
-				}(file)
-			}
-		}
-
-		// Detect deleted files and remove corresponding output files
-		for _, oldFile := range oldFiles {
-			if !slices.Contains(files, oldFile) {
-				log.InfoContext(ctx, "file removed", "file", oldFile)
-
-				// Delete all output files that were generated by this file
-				iw.fileToSvgMutex.RLock()
-				svgs, exists := iw.fileToSvgMap[oldFile]
-				iw.fileToSvgMutex.RUnlock()
-
-				if exists {
-					for svgPath := range svgs {
-						if err := os.Remove(svgPath); err != nil {
-							if !os.IsNotExist(err) {
-								log.ErrorContext(ctx, "failed to delete output file", "file", svgPath, "error", err)
-
-							}
-						} else {
-							log.InfoContext(ctx, "deleted orphaned output file", "file", svgPath)
-						}
-					}
-					// Remove the mapping
-					iw.fileToSvgMutex.Lock()
-					delete(iw.fileToSvgMap, oldFile)
-					iw.fileToSvgMutex.Unlock()
-				}
-			}
-		}
-
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-time.After(100 * time.Millisecond):
-		}
-
-		oldFiles = files
-		files = iw.GetFiles(ctx)
-	}
-}
@@ -0,0 +1,209 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+)
+
+// fakeRenderer writes "<svg>" plus the source for every render. It blocks
+// until cancelled when the source contains "slow" and reports an error on
+// line 2 when it contains "broken".
+type fakeRenderer struct{}
+
+func (fakeRenderer) ExecuteWithFormat(ctx context.Context, input, output, format string) (string, error) {
+	source, err := os.ReadFile(input)
+	if err != nil {
+		return err.Error(), err
+	}
+
+	if strings.Contains(string(source), "slow") {
+		<-ctx.Done()
+		return "killed", ctx.Err()
+	}
+
+	if strings.Contains(string(source), "broken") {
+		return "Error line 2 in file: " + input + "\nSyntax Error?", errors.New("exit status 200")
+	}
+
+	if err := os.MkdirAll(output, 0o755); err != nil {
+		return err.Error(), err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(input), ".puml") + "." + format
+	return "", os.WriteFile(filepath.Join(output, name), append([]byte("<svg>"), source...), 0o644)
+}
+
+func (fakeRenderer) Version() string {
+	return "fake"
+}
+
+func newTestWatcher(t *testing.T, opts Options) (*InputWatcher, string, string) {
+	t.Helper()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	return New(inputDir, outputDir, fakeRenderer{}, opts), inputDir, outputDir
+}
+
+func writeInput(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s failed: %v", path, err)
+	}
+}
+
+func TestRegenerateIfNeededReportsTimeout(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, _ := newTestWatcher(t, Options{RenderTimeout: 50 * time.Millisecond})
+	input := filepath.Join(inputDir, "slow.puml")
+	writeInput(t, input, "@startuml\nslow\n@enduml\n")
+
+	result := iw.RegenerateIfNeeded(context.Background(), input)
+	if result.OK {
+		t.Fatal("expected timed out render to fail")
+	}
+	if result.Message != "Rendering timed out after 50ms" {
+		t.Fatalf("unexpected message %q", result.Message)
+	}
+}
+
+func TestRegenerateIfNeededCancelsSupersededRender(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, outputDir := newTestWatcher(t, Options{})
+	input := filepath.Join(inputDir, "diagram.puml")
+	writeInput(t, input, "@startuml\nslow\n@enduml\n")
+
+	superseded := make(chan CompileResult)
+	go func() { superseded <- iw.RegenerateIfNeeded(context.Background(), input) }()
+
+	// Wait for the slow render to start, then save newer content
+	deadline := time.Now().Add(time.Second)
+	for {
+		iw.inflightMutex.Lock()
+		_, running := iw.inflight[input]
+		iw.inflightMutex.Unlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("render never started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	writeInput(t, input, "@startuml\nfast\n@enduml\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+
+	result := iw.RegenerateIfNeeded(context.Background(), input)
+	if !result.OK {
+		t.Fatalf("expected newest render to succeed: %#v", result)
+	}
+
+	select {
+	case old := <-superseded:
+		if old.OK {
+			t.Fatal("expected superseded render to be cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("superseded render was not cancelled")
+	}
+
+	svg, err := os.ReadFile(filepath.Join(outputDir, "diagram.svg"))
+	if err != nil || !strings.Contains(string(svg), "fast") {
+		t.Fatalf("expected newest content to be rendered, got %q (err %v)", svg, err)
+	}
+}
+
+func TestRegenerateIfNeededReturnsDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, _ := newTestWatcher(t, Options{})
+	if err := os.MkdirAll(filepath.Join(inputDir, "nested"), 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	input := filepath.Join(inputDir, "nested", "diagram.puml")
+	writeInput(t, input, "@startuml\nA -> broken  \n@enduml\n")
+
+	result := iw.RegenerateIfNeeded(context.Background(), input)
+	if result.OK {
+		t.Fatal("expected compile error")
+	}
+
+	want := []plantuml.Diagnostic{{
+		File:     "nested/diagram.puml",
+		Line:     2,
+		Severity: plantuml.SeverityError,
+		Message:  "Syntax Error?",
+		Context:  "A -> broken",
+	}}
+	if !reflect.DeepEqual(result.Diagnostics, want) {
+		t.Fatalf("unexpected diagnostics:\ngot  %#v\nwant %#v", result.Diagnostics, want)
+	}
+}
+
+func TestRegenerateIfNeededPublishesFailure(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, outputDir := newTestWatcher(t, Options{})
+	input := filepath.Join(inputDir, "diagram.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.ExecuteAndTrack(context.Background(), input, outputDir)
+
+	events, unsubscribe := iw.Subscribe()
+	defer unsubscribe()
+
+	writeInput(t, input, "@startuml\nA -> broken\n@enduml\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+	iw.RegenerateIfNeeded(context.Background(), input)
+
+	select {
+	case event := <-events:
+		if event.Type != EventFailed || event.Diagram != "diagram" {
+			t.Fatalf("unexpected event %#v", event)
+		}
+		if len(event.Result.Diagnostics) != 1 || event.Result.Diagnostics[0].Line != 2 {
+			t.Fatalf("expected diagnostics in event, got %#v", event.Result.Diagnostics)
+		}
+	default:
+		t.Fatal("expected a failed event")
+	}
+}
+
+func TestPublishMovesReportsRename(t *testing.T) {
+	t.Parallel()
+
+	iw, _, _ := newTestWatcher(t, Options{})
+	events, unsubscribe := iw.Subscribe()
+	defer unsubscribe()
+
+	iw.publishMoves(context.Background(), []string{"old"}, []string{"new"})
+	iw.publishMoves(context.Background(), []string{"a", "b"}, []string{"c"})
+
+	want := []Event{
+		{Type: EventRenamed, Diagram: "old", NewDiagram: "new"},
+		{Type: EventDeleted, Diagram: "a"},
+		{Type: EventDeleted, Diagram: "b"},
+	}
+	for _, expected := range want {
+		if got := <-events; !reflect.DeepEqual(got, expected) {
+			t.Fatalf("got event %#v, want %#v", got, expected)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package inputwatcher
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// variantsDirName holds outputs rendered with render options, inside the
+// data directory so they don't show up as diagrams.
+const variantsDirName = "variants"
+
+// OutputForDiagram returns the path of the diagram rendered in format with
+// opts. Only SVG without options is generated when a source changes, other
+// outputs are rendered on the first request and kept until the source
+// changes again.
+func (iw *InputWatcher) OutputForDiagram(ctx context.Context, outputRel, format string, opts plantuml.RenderOptions) (string, error) {
+	svgFile, err := iw.outputPathForDiagram(outputRel)
+	if err != nil {
+		return "", err
+	}
+
+	inputFile, ok := iw.ResolveInputForOutput(svgFile)
+	if !ok {
+		return "", ErrOutputNotTracked
+	}
+
+	// Bring the SVG up to date first, which also drops outputs of an older version
+	if result := iw.RegenerateIfNeeded(ctx, inputFile); !result.OK {
+		return "", fmt.Errorf("%w: %s", ErrRenderFailed, result.Message)
+	}
+
+	outputDir := iw.calculateOutputDir(ctx, inputFile)
+	outputFile := strings.TrimSuffix(svgFile, ".svg") + "." + plantuml.FormatExtension(format)
+	if !opts.IsZero() {
+		// Variants mirror the output folder below a directory per option set
+		variantRoot := filepath.Join(iw.variantsPath(), opts.Key())
+		if outputDir, err = iw.mirrorOutputPath(outputDir, variantRoot); err != nil {
+			return "", err
+		}
+		if outputFile, err = iw.mirrorOutputPath(outputFile, variantRoot); err != nil {
+			return "", err
+		}
+	}
+
+	if iw.isTrackedOutput(inputFile, outputFile) {
+		return outputFile, nil
+	}
+
+	lock := iw.getFileLock(inputFile)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Another request may have rendered it while this one waited for the lock
+	if iw.isTrackedOutput(inputFile, outputFile) {
+		return outputFile, nil
+	}
+
+	// Somebody is waiting for the download, render it ahead of background work
+	removeViewer := iw.addInputViewer(inputFile)
+	defer removeViewer()
+
+	log.InfoContext(ctx, "rendering output on demand", "input", inputFile, "format", format, "options", opts.Key())
+
+	mtimesBefore := iw.outputModTimes(ctx, outputDir)
+	if outputText, err := iw.render(ctx, inputFile, outputDir, format, opts); err != nil {
+		if outputText == "" {
+			outputText = err.Error()
+		}
+		return "", fmt.Errorf("%w: %s", ErrRenderFailed, outputText)
+	}
+
+	generated := iw.changedOutputs(ctx, outputDir, "."+plantuml.FormatExtension(format), mtimesBefore)
+	if _, err := os.Stat(outputFile); err == nil {
+		generated[outputFile] = true
+	}
+
+	iw.fileToSvgMutex.Lock()
+	if outputs, ok := iw.fileToSvgMap[inputFile]; ok {
+		for path := range generated {
+			outputs[path] = true
+		}
+	}
+	iw.fileToSvgMutex.Unlock()
+	iw.markStateChanged()
+
+	if !generated[outputFile] {
+		return "", ErrOutputNotTracked
+	}
+
+	return outputFile, nil
+}
+
+func (iw *InputWatcher) isTrackedOutput(inputFile, outputFile string) bool {
+	iw.fileToSvgMutex.RLock()
+	defer iw.fileToSvgMutex.RUnlock()
+
+	if !iw.fileToSvgMap[inputFile][outputFile] {
+		return false
+	}
+
+	_, err := os.Stat(outputFile)
+	return err == nil
+}
+
+func (iw *InputWatcher) variantsPath() string {
+	return filepath.Join(iw.outputPath, DataDirName, variantsDirName)
+}
+
+// mirrorOutputPath moves a path below the output folder to the same place
+// below root.
+func (iw *InputWatcher) mirrorOutputPath(path, root string) (string, error) {
+	relPath, err := filepath.Rel(iw.outputPath, path)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(root, relPath), nil
+}
+
+// writeVariantSource writes the source of inputFile with opts applied to a
+// temporary file of the same name. Local includes are made absolute so they
+// still resolve from there. The returned function removes the file.
+func (iw *InputWatcher) writeVariantSource(inputFile string, opts plantuml.RenderOptions) (string, func(), error) {
+	source, err := os.ReadFile(inputFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	inputDir, err := filepath.Abs(filepath.Dir(inputFile))
+	if err != nil {
+		return "", nil, err
+	}
+
+	dataDir := filepath.Join(iw.outputPath, DataDirName)
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return "", nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp(dataDir, "source-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { _ = os.RemoveAll(tmpDir) }
+
+	variantFile := filepath.Join(tmpDir, filepath.Base(inputFile))
+	content := opts.Apply(plantuml.AbsoluteIncludes(string(source), inputDir))
+	if err := os.WriteFile(variantFile, []byte(content), 0o644); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return variantFile, cleanup, nil
+}
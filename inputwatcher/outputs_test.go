@@ -0,0 +1,119 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+)
+
+func TestOutputForDiagramRendersOnDemand(t *testing.T) {
+	t.Parallel()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	input := filepath.Join(inputDir, "diagram.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+
+	renderer := &countingRenderer{}
+	iw := New(inputDir, outputDir, renderer, Options{})
+	iw.RegenerateIfNeeded(context.Background(), input)
+
+	png := filepath.Join(outputDir, "diagram.png")
+	if _, err := os.Stat(png); !os.IsNotExist(err) {
+		t.Fatalf("expected png not to be rendered eagerly, got %v", err)
+	}
+
+	for range 2 {
+		path, err := iw.OutputForDiagram(context.Background(), "diagram", "png", plantuml.RenderOptions{})
+		if err != nil {
+			t.Fatalf("OutputForDiagram failed: %v", err)
+		}
+		if path != png {
+			t.Fatalf("expected %s, got %s", png, path)
+		}
+	}
+	if got := renderer.renders.Load(); got != 2 {
+		t.Fatalf("expected svg and one png render, got %d", got)
+	}
+
+	// A new version of the source invalidates the png
+	writeInput(t, input, "@startuml\nA -> C\n@enduml\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+	iw.RegenerateIfNeeded(context.Background(), input)
+	if _, err := os.Stat(png); !os.IsNotExist(err) {
+		t.Fatalf("expected stale png to be deleted, got %v", err)
+	}
+
+	if _, err := iw.OutputForDiagram(context.Background(), "diagram", "png", plantuml.RenderOptions{}); err != nil {
+		t.Fatalf("OutputForDiagram failed: %v", err)
+	}
+	if data, err := os.ReadFile(png); err != nil || string(data) != "<svg>@startuml\nA -> C\n@enduml\n" {
+		t.Fatalf("expected png of the new source, got %q (%v)", data, err)
+	}
+}
+
+func TestOutputForDiagramReportsFailures(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, _ := newTestWatcher(t, Options{})
+	input := filepath.Join(inputDir, "diagram.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.RegenerateIfNeeded(context.Background(), input)
+
+	if _, err := iw.OutputForDiagram(context.Background(), "missing", "png", plantuml.RenderOptions{}); !errors.Is(err, ErrOutputNotTracked) {
+		t.Fatalf("expected ErrOutputNotTracked, got %v", err)
+	}
+
+	writeInput(t, input, "@startuml\nbroken\n@enduml\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+	if _, err := iw.OutputForDiagram(context.Background(), "diagram", "png", plantuml.RenderOptions{}); !errors.Is(err, ErrRenderFailed) {
+		t.Fatalf("expected ErrRenderFailed, got %v", err)
+	}
+}
+
+func TestOutputForDiagramRendersVariants(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, outputDir := newTestWatcher(t, Options{})
+	input := filepath.Join(inputDir, "diagram.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.RegenerateIfNeeded(context.Background(), input)
+
+	opts := plantuml.RenderOptions{Scale: 2, DPI: 300}
+	path, err := iw.OutputForDiagram(context.Background(), "diagram", "png", opts)
+	if err != nil {
+		t.Fatalf("OutputForDiagram failed: %v", err)
+	}
+
+	want := filepath.Join(outputDir, DataDirName, variantsDirName, "scale-2_dpi-300", "diagram.png")
+	if path != want {
+		t.Fatalf("expected %s, got %s", want, path)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "<svg>@startuml\nA -> B\nskinparam dpi 300\nscale 2\n@enduml\n" {
+		t.Fatalf("expected source with options applied, got %q (%v)", data, err)
+	}
+	if diagrams := iw.diagramsForInput(input); len(diagrams) != 1 || diagrams[0] != "diagram" {
+		t.Fatalf("expected variants not to be diagrams, got %v", diagrams)
+	}
+
+	writeInput(t, input, "@startuml\nA -> C\n@enduml\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(input, later, later); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+	iw.RegenerateIfNeeded(context.Background(), input)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected stale variant to be deleted, got %v", err)
+	}
+}
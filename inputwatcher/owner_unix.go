@@ -0,0 +1,26 @@
+//go:build !windows
+
+package inputwatcher
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// copyOwner gives path the owner and group of the file described by info.
+// Only root may give files away, so for other users a file they could write
+// but not own simply keeps them as owner.
+func copyOwner(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	err := os.Lchown(path, int(stat.Uid), int(stat.Gid))
+	if errors.Is(err, os.ErrPermission) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,150 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mishankov/plantuml-watch-server/gitrepo"
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/mishankov/plantuml-watch-server/rendercache"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// Revision is a git revision a diagram was rendered at.
+type Revision struct {
+	// Rev is the revision as requested, e.g. a branch
+	Rev    string
+	Commit string
+}
+
+// gitRepo opens the repository the input folder is part of, once.
+func (iw *InputWatcher) gitRepo(ctx context.Context) (*gitrepo.Repo, error) {
+	iw.repoOnce.Do(func() {
+		// The outcome is kept, it must not depend on the first request
+		iw.repo, iw.repoErr = gitrepo.Open(context.WithoutCancel(ctx), iw.inputPath)
+	})
+
+	return iw.repo, iw.repoErr
+}
+
+// InGitRepository reports whether the input folder is part of a git
+// repository, so diagrams can be rendered at its revisions.
+func (iw *InputWatcher) InGitRepository(ctx context.Context) bool {
+	_, err := iw.gitRepo(ctx)
+	return err == nil
+}
+
+// RenderAtRevision renders the SVG of a diagram from its source as it was at
+// a git revision. Local includes are read at the same revision, the working
+// tree is left alone and nothing is written to the output folder.
+func (iw *InputWatcher) RenderAtRevision(ctx context.Context, outputRel, rev string) (Revision, []byte, error) {
+	inputFile, err := iw.inputForDiagram(outputRel)
+	if err != nil {
+		return Revision{}, nil, err
+	}
+
+	repo, err := iw.gitRepo(ctx)
+	if err != nil {
+		return Revision{}, nil, err
+	}
+
+	commit, err := repo.Resolve(ctx, rev)
+	if err != nil {
+		return Revision{}, nil, err
+	}
+	revision := Revision{Rev: rev, Commit: commit}
+
+	resolvedInput, err := gitrepo.ResolvedPath(inputFile)
+	if err != nil {
+		return revision, nil, err
+	}
+	sourcePath, ok := repo.Rel(resolvedInput)
+	if !ok {
+		return revision, nil, fmt.Errorf("%w: %s is not part of the repository", gitrepo.ErrFileNotFound, inputFile)
+	}
+
+	source, err := repo.ReadFile(ctx, commit, sourcePath)
+	if err != nil {
+		return revision, nil, err
+	}
+
+	// Includes are resolved below the root of the repository, so they can be
+	// read at the commit. Files outside of it are read from disk.
+	readFile := func(path string) ([]byte, error) {
+		if rel, ok := repo.Rel(path); ok {
+			return repo.ReadFile(ctx, commit, rel)
+		}
+		return os.ReadFile(path)
+	}
+	content, err := plantuml.InlineIncludes(string(source), filepath.Dir(resolvedInput), readFile)
+	if err != nil {
+		return revision, nil, fmt.Errorf("%w: %w", ErrRenderFailed, err)
+	}
+
+	// Commits don't change, so a render is only repeated for another renderer
+	var key string
+	if iw.cache != nil {
+		key = rendercache.Key(iw.pulm.Version(), "revision", commit, outputRel, content)
+		if files, ok := iw.cache.Get(key); ok && len(files) == 1 {
+			return revision, files[0], nil
+		}
+	}
+
+	svg, err := iw.renderRevision(ctx, inputFile, content, outputRel)
+	if err != nil {
+		return revision, nil, err
+	}
+
+	if iw.cache != nil {
+		if err := iw.cache.Put(key, [][]byte{svg}); err != nil {
+			log.WarnContext(ctx, "failed to store revision render in cache", "diagram", outputRel, "commit", commit, "error", err)
+		}
+	}
+
+	return revision, svg, nil
+}
+
+// renderRevision renders content in place of inputFile in a temporary
+// directory and returns the SVG of the diagram.
+func (iw *InputWatcher) renderRevision(ctx context.Context, inputFile, content, outputRel string) ([]byte, error) {
+	dataDir := filepath.Join(iw.outputPath, DataDirName)
+	if err := os.MkdirAll(dataDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp(dataDir, "source-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			log.WarnContext(ctx, "failed to remove revision render", "dir", tmpDir, "error", err)
+		}
+	}()
+
+	sourceFile := filepath.Join(tmpDir, filepath.Base(inputFile))
+	if err := os.WriteFile(sourceFile, []byte(content), 0o644); err != nil {
+		return nil, err
+	}
+
+	outputDir := filepath.Join(tmpDir, "output")
+	outputText, err := iw.renderWithRenderer(ctx, inputFile, sourceFile, outputDir, "svg")
+	if err != nil {
+		if outputText == "" {
+			outputText = err.Error()
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRenderFailed, strings.TrimSpace(outputText))
+	}
+
+	// The diagram may have been named differently at the revision
+	svg, err := os.ReadFile(filepath.Join(outputDir, filepath.Base(filepath.FromSlash(outputRel))+".svg"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", gitrepo.ErrFileNotFound, outputRel)
+	}
+
+	return svg, err
+}
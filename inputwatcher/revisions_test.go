@@ -0,0 +1,138 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mishankov/plantuml-watch-server/gitrepo"
+	"github.com/mishankov/plantuml-watch-server/gitrepo/gitrepotest"
+	"github.com/mishankov/plantuml-watch-server/rendercache"
+)
+
+func TestRenderAtRevisionReadsIncludesAtRevision(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	iw, inputDir, outputDir := newTestWatcher(t, Options{})
+	gitrepotest.Init(t, inputDir)
+
+	input := filepath.Join(inputDir, "flow.puml")
+	include := filepath.Join(inputDir, "common.iuml")
+	writeInput(t, input, "@startuml\n!include common.iuml\nA -> B\n@enduml\n")
+	writeInput(t, include, "skinparam first\n")
+	gitrepotest.Commit(t, inputDir, "first")
+
+	writeInput(t, input, "@startuml\n!include common.iuml\nA -> C\n@enduml\n")
+	writeInput(t, include, "skinparam second\n")
+	gitrepotest.Commit(t, inputDir, "second")
+
+	writeInput(t, input, "@startuml\n!include common.iuml\nA -> D\n@enduml\n")
+	iw.RegenerateIfNeeded(ctx, input)
+	if !iw.InGitRepository(ctx) {
+		t.Fatal("expected the input folder to be in a repository")
+	}
+
+	for rev, want := range map[string][]string{
+		"HEAD~1": {"skinparam first", "A -> B"},
+		"HEAD":   {"skinparam second", "A -> C"},
+	} {
+		revision, svg, err := iw.RenderAtRevision(ctx, "flow", rev)
+		if err != nil {
+			t.Fatalf("RenderAtRevision(%s) failed: %v", rev, err)
+		}
+		if revision.Rev != rev || len(revision.Commit) != 40 {
+			t.Fatalf("unexpected revision %#v", revision)
+		}
+		for _, line := range want {
+			if !strings.Contains(string(svg), line) {
+				t.Fatalf("expected %q in the render at %s, got %q", line, rev, svg)
+			}
+		}
+	}
+
+	if _, _, err := iw.RenderAtRevision(ctx, "flow", "missing"); !errors.Is(err, gitrepo.ErrRevisionNotFound) {
+		t.Fatalf("expected unknown revision, got %v", err)
+	}
+
+	// Neither the working tree nor the outputs are touched
+	content, err := os.ReadFile(input)
+	if err != nil || !strings.Contains(string(content), "A -> D") {
+		t.Fatalf("expected the working tree source, got %q %v", content, err)
+	}
+	svg, err := os.ReadFile(filepath.Join(outputDir, "flow.svg"))
+	if err != nil || !strings.Contains(string(svg), "A -> D") {
+		t.Fatalf("expected the current render to be kept, got %q %v", svg, err)
+	}
+}
+
+func TestRenderAtRevisionServesRepeatedRendersFromCache(t *testing.T) {
+	t.Parallel()
+
+	cache, err := rendercache.New(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("rendercache.New failed: %v", err)
+	}
+
+	ctx := context.Background()
+	inputDir := t.TempDir()
+	renderer := &countingRenderer{}
+	iw := New(inputDir, t.TempDir(), renderer, Options{Cache: cache})
+	gitrepotest.Init(t, inputDir)
+
+	input := filepath.Join(inputDir, "flow.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	gitrepotest.Commit(t, inputDir, "first")
+	writeInput(t, input, "@startuml\nA -> C\n@enduml\n")
+	gitrepotest.Commit(t, inputDir, "second")
+	iw.RegenerateIfNeeded(ctx, input)
+	rendered := renderer.renders.Load()
+
+	_, first, err := iw.RenderAtRevision(ctx, "flow", "HEAD~1")
+	if err != nil {
+		t.Fatalf("RenderAtRevision failed: %v", err)
+	}
+	if got := renderer.renders.Load(); got != rendered+1 {
+		t.Fatalf("expected the revision to be rendered, renders went from %d to %d", rendered, got)
+	}
+	rendered++
+
+	_, again, err := iw.RenderAtRevision(ctx, "flow", "HEAD~1")
+	if err != nil {
+		t.Fatalf("repeated RenderAtRevision failed: %v", err)
+	}
+	if got := renderer.renders.Load(); got != rendered {
+		t.Fatalf("expected the repeated render to be served from cache, renders went from %d to %d", rendered, got)
+	}
+	if string(again) != string(first) {
+		t.Fatalf("expected the cached render %q, got %q", first, again)
+	}
+
+	// Another commit is rendered on its own
+	_, svg, err := iw.RenderAtRevision(ctx, "flow", "HEAD")
+	if err != nil || !strings.Contains(string(svg), "A -> C") {
+		t.Fatalf("expected the render at HEAD, got %q %v", svg, err)
+	}
+	if got := renderer.renders.Load(); got != rendered+1 {
+		t.Fatalf("expected another commit to be rendered, renders went from %d to %d", rendered, got)
+	}
+}
+
+func TestRenderAtRevisionOutsideRepository(t *testing.T) {
+	t.Parallel()
+
+	gitrepotest.SkipWithoutGit(t)
+
+	ctx := context.Background()
+	iw, inputDir, _ := newTestWatcher(t, Options{})
+	input := filepath.Join(inputDir, "flow.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.RegenerateIfNeeded(ctx, input)
+
+	if _, _, err := iw.RenderAtRevision(ctx, "flow", "HEAD"); !errors.Is(err, gitrepo.ErrNotRepository) {
+		t.Fatalf("expected ErrNotRepository, got %v", err)
+	}
+}
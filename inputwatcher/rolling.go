@@ -0,0 +1,124 @@
+package inputwatcher
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// entryIDLayout names backups and history entries after the time they were
+// taken, it sorts chronologically.
+const entryIDLayout = "20060102T150405.000000000Z"
+
+// rollingStore keeps the newest entries of a directory, named after the time
+// they were taken. Every entry is made of one file per extension in exts, the
+// first one identifies it.
+type rollingStore struct {
+	dir   string
+	exts  []string
+	limit int
+}
+
+// storedEntry is an entry of a rollingStore. Size is the size of its
+// identifying file.
+type storedEntry struct {
+	ID        string
+	CreatedAt time.Time
+	Size      int64
+}
+
+// path returns the file of an entry with the extension ext.
+func (s rollingStore) path(id, ext string) string {
+	return filepath.Join(s.dir, id+ext)
+}
+
+// list returns the entries, newest first.
+func (s rollingStore) list() ([]storedEntry, error) {
+	files, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []storedEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []storedEntry{}
+	for _, file := range files {
+		id, ok := strings.CutSuffix(file.Name(), s.exts[0])
+		if !ok || file.IsDir() {
+			continue
+		}
+
+		createdAt, err := time.Parse(entryIDLayout, id)
+		if err != nil {
+			continue
+		}
+
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, storedEntry{ID: id, CreatedAt: createdAt, Size: info.Size()})
+	}
+	slices.SortFunc(entries, func(a, b storedEntry) int {
+		return strings.Compare(b.ID, a.ID)
+	})
+
+	return entries, nil
+}
+
+// read returns the file of an entry with the extension ext. Malformed IDs
+// are reported as missing.
+func (s rollingStore) read(id, ext string) ([]byte, error) {
+	if _, err := time.Parse(entryIDLayout, id); err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	return os.ReadFile(s.path(id, ext))
+}
+
+// isLatest reports whether the identifying file of the newest of entries
+// holds content.
+func (s rollingStore) isLatest(entries []storedEntry, content []byte) bool {
+	if len(entries) == 0 {
+		return false
+	}
+
+	latest, err := os.ReadFile(s.path(entries[0].ID, s.exts[0]))
+	return err == nil && bytes.Equal(latest, content)
+}
+
+// add writes an entry with one content per extension and drops the oldest
+// entries beyond the limit.
+func (s rollingStore) add(id string, contents ...[]byte) error {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+
+	// The identifying file goes last, so a partly written entry isn't listed
+	for i := len(s.exts) - 1; i >= 0; i-- {
+		if err := os.WriteFile(s.path(id, s.exts[i]), contents[i], 0o644); err != nil {
+			return err
+		}
+	}
+
+	entries, err := s.list()
+	if err != nil {
+		return err
+	}
+
+	for _, old := range entries[min(len(entries), s.limit):] {
+		for _, ext := range s.exts {
+			if err := os.Remove(s.path(old.ID, ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
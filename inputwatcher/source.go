@@ -0,0 +1,130 @@
+package inputwatcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"time"
+
+	"github.com/platforma-dev/platforma/log"
+)
+
+// Source is the content of the source file of a diagram.
+type Source struct {
+	// Path is relative to the input folder.
+	Path    string
+	Content string
+	// Version identifies the content, it changes whenever the content does.
+	Version string
+}
+
+func sourceContentVersion(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:16])
+}
+
+func (iw *InputWatcher) readSource(inputFile string) (Source, error) {
+	content, err := os.ReadFile(inputFile)
+	if err != nil {
+		return Source{}, err
+	}
+
+	return Source{
+		Path:    iw.relativeInputPath(inputFile),
+		Content: string(content),
+		Version: sourceContentVersion(content),
+	}, nil
+}
+
+func (iw *InputWatcher) ReadSourceForOutput(outputRel string) (Source, error) {
+	inputFile, err := iw.inputForDiagram(outputRel)
+	if err != nil {
+		return Source{}, err
+	}
+
+	return iw.readSource(inputFile)
+}
+
+// WriteSourceForOutput saves the source of a diagram and renders it. Unless
+// baseVersion is empty, the source is only saved if it still has that
+// version; otherwise ErrSourceConflict is returned along with the current
+// source, so changes made elsewhere in the meantime are not lost. The file
+// is replaced atomically and its previous content is kept as a backup.
+func (iw *InputWatcher) WriteSourceForOutput(ctx context.Context, outputRel, content, baseVersion string) (Source, CompileResult, error) {
+	inputFile, err := iw.inputForDiagram(outputRel)
+	if err != nil {
+		return Source{}, CompileResult{}, err
+	}
+
+	return iw.writeSource(ctx, inputFile, content, baseVersion, false)
+}
+
+// writeSource implements WriteSourceForOutput. Backups of autosaves in quick
+// succession are skipped unless alwaysBackup is set.
+func (iw *InputWatcher) writeSource(ctx context.Context, inputFile, content, baseVersion string, alwaysBackup bool) (Source, CompileResult, error) {
+	// Don't cancel the render of a source the write is going to be refused for
+	if current, err := iw.readSource(inputFile); err != nil {
+		return Source{}, CompileResult{}, err
+	} else if baseVersion != "" && current.Version != baseVersion {
+		return current, CompileResult{}, ErrSourceConflict
+	}
+
+	// Whatever is rendering now is about to be overwritten
+	iw.cancelSuperseded(ctx, inputFile, time.Now())
+
+	lock := iw.getFileLock(inputFile)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// Check again, another save may have come first while waiting for the lock
+	current, err := iw.readSource(inputFile)
+	if err != nil {
+		return Source{}, CompileResult{}, err
+	}
+	if baseVersion != "" && current.Version != baseVersion {
+		return current, CompileResult{}, ErrSourceConflict
+	}
+
+	if current.Content != content {
+		if err := iw.backupSource(inputFile, []byte(current.Content), alwaysBackup); err != nil {
+			log.WarnContext(ctx, "failed to back up source before saving", "input", inputFile, "error", err)
+		}
+	}
+
+	if err := writeFileAtomic(inputFile, []byte(content)); err != nil {
+		return Source{}, CompileResult{}, err
+	}
+
+	version, err := iw.sourceVersion(inputFile)
+	if err != nil {
+		return Source{}, CompileResult{}, err
+	}
+
+	hash, err := iw.sourceHash(inputFile)
+	if err != nil {
+		return Source{}, CompileResult{}, err
+	}
+
+	renderCtx, done := iw.beginRender(ctx, inputFile, version)
+	defer done()
+
+	started := time.Now()
+	result := iw.ExecuteAndTrack(renderCtx, inputFile, iw.calculateOutputDir(ctx, inputFile))
+	if renderCtx.Err() == nil || ctx.Err() != nil {
+		iw.setCompileResult(inputFile, trackedGeneration{
+			ModTime:    version,
+			Hash:       hash,
+			Result:     result,
+			RenderedAt: time.Now(),
+			Duration:   time.Since(started),
+		})
+		iw.publishResult(ctx, inputFile, result)
+	}
+
+	return Source{
+		Path:    iw.relativeInputPath(inputFile),
+		Content: content,
+		Version: sourceContentVersion([]byte(content)),
+	}, result, nil
+}
@@ -0,0 +1,57 @@
+package inputwatcher
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteSourceForOutputDetectsConflicts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	iw, inputDir, _ := newTestWatcher(t, Options{})
+	input := filepath.Join(inputDir, "flow.puml")
+	writeInput(t, input, "@startuml\nA -> B\n@enduml\n")
+	iw.RegenerateIfNeeded(ctx, input)
+
+	loaded, err := iw.ReadSourceForOutput("flow")
+	if err != nil {
+		t.Fatalf("ReadSourceForOutput failed: %v", err)
+	}
+	if loaded.Path != "flow.puml" || loaded.Version == "" {
+		t.Fatalf("unexpected source %#v", loaded)
+	}
+
+	saved, result, err := iw.WriteSourceForOutput(ctx, "flow", "@startuml\nA -> C\n@enduml\n", loaded.Version)
+	if err != nil || !result.OK {
+		t.Fatalf("WriteSourceForOutput failed: %v %#v", err, result)
+	}
+	if saved.Version == loaded.Version {
+		t.Fatal("expected a new version after saving")
+	}
+
+	// Somebody else edits the file, the save based on the old version is refused
+	writeInput(t, input, "@startuml\nA -> D\n@enduml\n")
+	current, _, err := iw.WriteSourceForOutput(ctx, "flow", "@startuml\nA -> E\n@enduml\n", saved.Version)
+	if !errors.Is(err, ErrSourceConflict) {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if current.Content != "@startuml\nA -> D\n@enduml\n" {
+		t.Fatalf("expected the current source with the conflict, got %q", current.Content)
+	}
+
+	content, err := os.ReadFile(input)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if string(content) != current.Content {
+		t.Fatalf("expected the file to be left alone, got %q", content)
+	}
+
+	if _, _, err := iw.WriteSourceForOutput(ctx, "flow", "@startuml\nA -> E\n@enduml\n", current.Version); err != nil {
+		t.Fatalf("expected save based on the current version to succeed: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package inputwatcher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// DataDirName is the hidden directory inside the output folder holding the
+// server's own files. Hidden directories are never listed as diagrams.
+const DataDirName = ".pumlws"
+
+const (
+	stateFileName      = "state.json"
+	stateFormatVersion = 1
+	// stateSaveDelay batches the state writes of renders finishing together.
+	stateSaveDelay = time.Second
+)
+
+// savedState is persisted between runs so unchanged diagrams are not
+// rendered again on startup.
+type savedState struct {
+	Version int `json:"version"`
+	// Sources is keyed by the source path relative to the input folder.
+	Sources map[string]savedSource `json:"sources"`
+}
+
+type savedSource struct {
+	// Hash fingerprints the source and everything it includes.
+	Hash string `json:"hash"`
+	OK   bool   `json:"ok"`
+	// Outputs are relative to the output folder.
+	Outputs    []string      `json:"outputs"`
+	RenderedAt time.Time     `json:"renderedAt,omitzero"`
+	Duration   time.Duration `json:"duration,omitempty"`
+}
+
+func (iw *InputWatcher) statePath() string {
+	return filepath.Join(iw.outputPath, DataDirName, stateFileName)
+}
+
+// sourceHash fingerprints the content of inputFile and everything it
+// includes. Includes are named relative to inputFile, so copies of a diagram
+// with the same includes share the fingerprint.
+func (iw *InputWatcher) sourceHash(inputFile string) (string, error) {
+	iw.scanIncludes(inputFile)
+
+	hash := sha256.New()
+	for _, file := range append([]string{inputFile}, iw.includes.closure(inputFile)...) {
+		name := ""
+		if file != inputFile {
+			name, _ = filepath.Rel(filepath.Dir(inputFile), file)
+		}
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			if file == inputFile {
+				return "", err
+			}
+
+			// A missing include is part of the fingerprint too
+			fmt.Fprintf(hash, "%s\x00missing\x00", name)
+			continue
+		}
+
+		fmt.Fprintf(hash, "%s\x00%d\x00", name, len(content))
+		hash.Write(content)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// LoadState restores the outputs recorded by a previous run. Sources whose
+// content and includes are unchanged are not rendered again, outputs of
+// sources that no longer exist are deleted.
+func (iw *InputWatcher) LoadState(ctx context.Context) error {
+	data, err := os.ReadFile(iw.statePath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read state: %w", err)
+	}
+
+	var state savedState
+	if err := json.Unmarshal(data, &state); err != nil || state.Version != stateFormatVersion {
+		log.WarnContext(ctx, "ignoring unreadable state file", "file", iw.statePath(), "error", err)
+		return nil
+	}
+
+	reused := 0
+	for relInput, source := range state.Sources {
+		inputFile := filepath.Join(iw.inputPath, filepath.FromSlash(relInput))
+		outputs := make(map[string]bool, len(source.Outputs))
+		for _, relOutput := range source.Outputs {
+			outputs[filepath.Join(iw.outputPath, filepath.FromSlash(relOutput))] = true
+		}
+
+		if _, err := os.Stat(inputFile); err != nil {
+			log.InfoContext(ctx, "source removed since last run", "file", inputFile)
+			iw.deleteOutputs(ctx, outputs)
+			continue
+		}
+
+		// Stale outputs are tracked too, so the next render cleans up the ones
+		// it no longer generates
+		iw.fileToSvgMutex.Lock()
+		iw.fileToSvgMap[inputFile] = outputs
+		iw.fileToSvgMutex.Unlock()
+
+		if !source.OK || !allExist(outputs) {
+			continue
+		}
+
+		hash, err := iw.sourceHash(inputFile)
+		if err != nil || hash != source.Hash {
+			continue
+		}
+
+		version, err := iw.sourceVersion(inputFile)
+		if err != nil {
+			continue
+		}
+
+		iw.setCompileResult(inputFile, trackedGeneration{
+			ModTime:    version,
+			Hash:       hash,
+			Result:     CompileResult{OK: true},
+			RenderedAt: source.RenderedAt,
+			Duration:   source.Duration,
+		})
+		reused++
+	}
+
+	log.InfoContext(ctx, "restored outputs of previous run", "reused", reused, "sources", len(state.Sources))
+	return nil
+}
+
+func allExist(files map[string]bool) bool {
+	for file := range files {
+		if _, err := os.Stat(file); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// saveState writes the tracked outputs and source hashes to the state file.
+func (iw *InputWatcher) saveState() error {
+	state := savedState{Version: stateFormatVersion, Sources: map[string]savedSource{}}
+
+	iw.fileToSvgMutex.RLock()
+	iw.compileMutex.RLock()
+	for inputFile, outputs := range iw.fileToSvgMap {
+		tracked := iw.compileCache[inputFile]
+
+		relOutputs := []string{}
+		for outputFile := range outputs {
+			if relOutput, err := filepath.Rel(iw.outputPath, outputFile); err == nil {
+				relOutputs = append(relOutputs, filepath.ToSlash(relOutput))
+			}
+		}
+		slices.Sort(relOutputs)
+
+		state.Sources[iw.relativeInputPath(inputFile)] = savedSource{
+			Hash:       tracked.Hash,
+			OK:         tracked.Result.OK,
+			Outputs:    relOutputs,
+			RenderedAt: tracked.RenderedAt,
+			Duration:   tracked.Duration,
+		}
+	}
+	iw.compileMutex.RUnlock()
+	iw.fileToSvgMutex.RUnlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	iw.stateMutex.Lock()
+	defer iw.stateMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(iw.statePath()), 0o755); err != nil {
+		return err
+	}
+
+	// Write next to the state file and rename, so a crash never leaves half a file
+	tmp := iw.statePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, iw.statePath())
+}
+
+// markStateChanged schedules the state file to be saved.
+func (iw *InputWatcher) markStateChanged() {
+	select {
+	case iw.stateChanged <- struct{}{}:
+	default:
+	}
+}
+
+// persistState saves the state file shortly after it changed until ctx is
+// done.
+func (iw *InputWatcher) persistState(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-iw.stateChanged:
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(stateSaveDelay):
+		}
+
+		if err := iw.saveState(); err != nil {
+			log.ErrorContext(ctx, "failed to save state", "error", err)
+		}
+	}
+}
+
+// removeOrphans deletes generated files in the output folder that no source
+// generates anymore.
+func (iw *InputWatcher) removeOrphans(ctx context.Context) {
+	tracked := map[string]bool{}
+	iw.fileToSvgMutex.RLock()
+	for _, outputs := range iw.fileToSvgMap {
+		for outputFile := range outputs {
+			tracked[outputFile] = true
+		}
+	}
+	iw.fileToSvgMutex.RUnlock()
+
+	orphans := map[string]bool{}
+	for _, dir := range []string{iw.outputPath, iw.variantsPath()} {
+		for outputFile := range iw.getSvgFilesInDir(ctx, dir) {
+			if !tracked[outputFile] {
+				orphans[outputFile] = true
+			}
+		}
+	}
+
+	iw.deleteOutputs(ctx, orphans)
+}
+
+// deleteOutputs removes generated files, ignoring ones that are already gone.
+func (iw *InputWatcher) deleteOutputs(ctx context.Context, outputs map[string]bool) {
+	for outputFile := range outputs {
+		if err := os.Remove(outputFile); err != nil {
+			if !os.IsNotExist(err) {
+				log.ErrorContext(ctx, "failed to delete output file", "file", outputFile, "error", err)
+			}
+		} else {
+			log.InfoContext(ctx, "deleted orphaned output file", "file", outputFile)
+		}
+	}
+}
+
+// isOutputFile reports whether path has the extension of an output format,
+// making it a file the watcher generates and may delete.
+func isOutputFile(path string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(path), ".")
+	return slices.ContainsFunc(plantuml.Formats(), func(format plantuml.Format) bool {
+		return format.Extension == ext
+	})
+}
+
+// isHiddenDir reports whether a directory below root is hidden, like the data
+// directory.
+func isHiddenDir(root, path string) bool {
+	return path != root && strings.HasPrefix(filepath.Base(path), ".")
+}
@@ -0,0 +1,108 @@
+package inputwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// countingRenderer counts the renders passed on to fakeRenderer.
+type countingRenderer struct {
+	renders atomic.Int32
+}
+
+func (r *countingRenderer) ExecuteWithFormat(ctx context.Context, input, output, format string) (string, error) {
+	r.renders.Add(1)
+	return fakeRenderer{}.ExecuteWithFormat(ctx, input, output, format)
+}
+
+func (r *countingRenderer) Version() string {
+	return "fake"
+}
+
+func TestLoadStateReusesUnchangedOutputs(t *testing.T) {
+	t.Parallel()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	kept := filepath.Join(inputDir, "kept.puml")
+	changed := filepath.Join(inputDir, "changed.puml")
+	removed := filepath.Join(inputDir, "removed.puml")
+	for _, file := range []string{kept, changed, removed} {
+		writeInput(t, file, "@startuml\n"+filepath.Base(file)+"\n@enduml\n")
+	}
+
+	first := New(inputDir, outputDir, fakeRenderer{}, Options{})
+	for _, file := range []string{kept, changed, removed} {
+		if result := first.RegenerateIfNeeded(context.Background(), file); !result.OK {
+			t.Fatalf("render of %s failed: %#v", file, result)
+		}
+	}
+	if err := first.saveState(); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+
+	writeInput(t, changed, "@startuml\nchanged again\n@enduml\n")
+	if err := os.Remove(removed); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	orphan := filepath.Join(outputDir, "orphan.svg")
+	writeInput(t, orphan, "<svg/>")
+
+	renderer := &countingRenderer{}
+	second := New(inputDir, outputDir, renderer, Options{})
+	if err := second.LoadState(context.Background()); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "removed.svg")); !os.IsNotExist(err) {
+		t.Fatalf("expected outputs of removed source to be deleted, got %v", err)
+	}
+
+	second.generateAll(context.Background())
+
+	// Only changed.puml is rendered again
+	if got := renderer.renders.Load(); got != 1 {
+		t.Fatalf("expected 1 render, got %d", got)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("expected orphan to be deleted, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "kept.svg")); err != nil {
+		t.Fatalf("expected reused output to stay: %v", err)
+	}
+	if _, ok := second.CompileResultForOutput("kept"); !ok {
+		t.Fatal("expected reused output to be tracked")
+	}
+}
+
+func TestLoadStateRendersWhenIncludeChanged(t *testing.T) {
+	t.Parallel()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+	main := filepath.Join(inputDir, "main.puml")
+	styles := filepath.Join(inputDir, "_styles.puml")
+	writeInput(t, main, "@startuml\n!include _styles.puml\nA -> B\n@enduml\n")
+	writeInput(t, styles, "skinparam monochrome true\n")
+
+	first := New(inputDir, outputDir, fakeRenderer{}, Options{})
+	first.RegenerateIfNeeded(context.Background(), main)
+	if err := first.saveState(); err != nil {
+		t.Fatalf("saveState failed: %v", err)
+	}
+
+	writeInput(t, styles, "skinparam monochrome false\n")
+
+	renderer := &countingRenderer{}
+	second := New(inputDir, outputDir, renderer, Options{})
+	if err := second.LoadState(context.Background()); err != nil {
+		t.Fatalf("LoadState failed: %v", err)
+	}
+	second.generateAll(context.Background())
+
+	if got := renderer.renders.Load(); got == 0 {
+		t.Fatal("expected main.puml to be rendered again after its include changed")
+	}
+}
@@ -0,0 +1,131 @@
+package inputwatcher
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DiagramStatus describes a diagram and the last render of its source.
+type DiagramStatus struct {
+	Name string
+	// Source is relative to the input folder.
+	Source string
+	// Outputs are the files generated for the diagram in every format,
+	// relative to the output folder.
+	Outputs []string
+	// Compiled reports whether the source was rendered since it last
+	// changed; Result, RenderedAt and RenderDuration are only set if it was.
+	Compiled       bool
+	Result         CompileResult
+	SourceModified time.Time
+	RenderedAt     time.Time
+	RenderDuration time.Duration
+}
+
+// Diagrams returns the status of every diagram, sorted by name. Sources
+// that never rendered successfully are listed under the name of the SVG they
+// would generate.
+func (iw *InputWatcher) Diagrams() []DiagramStatus {
+	inputs := map[string]bool{}
+	iw.fileToSvgMutex.RLock()
+	for inputFile := range iw.fileToSvgMap {
+		inputs[inputFile] = true
+	}
+	iw.fileToSvgMutex.RUnlock()
+
+	iw.compileMutex.RLock()
+	for inputFile := range iw.compileCache {
+		inputs[inputFile] = true
+	}
+	iw.compileMutex.RUnlock()
+
+	diagrams := []DiagramStatus{}
+	for inputFile := range inputs {
+		diagrams = append(diagrams, iw.inputStatus(inputFile)...)
+	}
+	slices.SortFunc(diagrams, func(a, b DiagramStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return diagrams
+}
+
+// Diagram returns the status of a single diagram.
+func (iw *InputWatcher) Diagram(name string) (DiagramStatus, bool) {
+	name = filepath.ToSlash(filepath.Clean(name))
+	for _, status := range iw.Diagrams() {
+		if status.Name == name {
+			return status, true
+		}
+	}
+
+	return DiagramStatus{}, false
+}
+
+// inputStatus returns the status of the diagrams generated by inputFile.
+func (iw *InputWatcher) inputStatus(inputFile string) []DiagramStatus {
+	iw.compileMutex.RLock()
+	tracked, compiled := iw.compileCache[inputFile]
+	iw.compileMutex.RUnlock()
+
+	iw.fileToSvgMutex.RLock()
+	outputs := make([]string, 0, len(iw.fileToSvgMap[inputFile]))
+	for outputFile := range iw.fileToSvgMap[inputFile] {
+		outputs = append(outputs, outputFile)
+	}
+	iw.fileToSvgMutex.RUnlock()
+	slices.Sort(outputs)
+
+	var sourceModified time.Time
+	if version, err := iw.sourceVersion(inputFile); err == nil {
+		sourceModified = version
+	}
+
+	newStatus := func(name string) DiagramStatus {
+		status := DiagramStatus{
+			Name:           name,
+			Source:         iw.relativeInputPath(inputFile),
+			Outputs:        []string{},
+			Compiled:       compiled,
+			SourceModified: sourceModified,
+		}
+		if compiled {
+			status.Result = tracked.Result
+			status.RenderedAt = tracked.RenderedAt
+			status.RenderDuration = tracked.Duration
+		}
+
+		return status
+	}
+
+	statuses := []DiagramStatus{}
+	for _, svgFile := range outputs {
+		name, ok := iw.diagramName(svgFile)
+		if !ok {
+			continue
+		}
+
+		status := newStatus(name)
+
+		// Outputs of the diagram share the name of its SVG
+		base := strings.TrimSuffix(svgFile, ".svg")
+		for _, outputFile := range outputs {
+			if strings.TrimSuffix(outputFile, filepath.Ext(outputFile)) != base {
+				continue
+			}
+			if relOutput, err := filepath.Rel(iw.outputPath, outputFile); err == nil {
+				status.Outputs = append(status.Outputs, filepath.ToSlash(relOutput))
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	if len(statuses) == 0 {
+		return []DiagramStatus{newStatus(strings.TrimSuffix(iw.relativeInputPath(inputFile), ".puml"))}
+	}
+
+	return statuses
+}
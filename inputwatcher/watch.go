@@ -0,0 +1,435 @@
+package inputwatcher
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/platforma-dev/platforma/log"
+)
+
+// WatchMode selects how Run notices changes in the input folder.
+type WatchMode string
+
+const (
+	// WatchAuto uses file system events and falls back to polling when they
+	// are not available. A slow poll catches changes on file systems that
+	// accept watches but never deliver events.
+	WatchAuto WatchMode = "auto"
+	// WatchNotify uses file system events (inotify, kqueue, ...) only.
+	WatchNotify WatchMode = "notify"
+	// WatchPoll periodically compares sizes and modification times, for file
+	// systems that don't deliver events such as some bind mounts.
+	WatchPoll WatchMode = "poll"
+)
+
+const (
+	defaultPollInterval = time.Second
+	defaultDebounce     = 100 * time.Millisecond
+
+	// safetyNetInterval is how often auto mode polls next to file system
+	// events.
+	safetyNetInterval = 10 * time.Second
+
+	// changeBuffer absorbs bursts of events while a batch is being applied.
+	changeBuffer = 256
+)
+
+// fileStamp is what polling compares to detect a changed file.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+func (iw *InputWatcher) Run(ctx context.Context) error {
+	iw.background.Go(func() { iw.persistState(ctx) })
+
+	// Start watching first so changes made during the initial generation are not missed
+	changes := make(chan string, changeBuffer)
+	switch iw.watchMode {
+	case WatchPoll:
+		go iw.poll(ctx, changes, iw.pollInterval)
+	default:
+		err := iw.notify(ctx, changes)
+		switch {
+		case err != nil && iw.watchMode == WatchNotify:
+			return fmt.Errorf("watch input folder: %w", err)
+		case err != nil:
+			log.WarnContext(ctx, "file system events are unavailable, falling back to polling", "error", err)
+			go iw.poll(ctx, changes, iw.pollInterval)
+		case iw.watchMode == WatchAuto:
+			// Some bind mounts accept watches but never deliver events
+			go iw.poll(ctx, changes, max(iw.pollInterval, safetyNetInterval))
+		}
+	}
+
+	known := map[string]bool{}
+	for file := range iw.generateAll(ctx) {
+		known[file] = true
+	}
+
+	pending := map[string]bool{}
+	debounce := time.NewTimer(iw.debounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Cancelled renders finish quickly, the state then covers them
+			iw.background.Wait()
+			if err := iw.saveState(); err != nil {
+				log.ErrorContext(ctx, "failed to save state", "error", err)
+			}
+			return ctx.Err()
+		case path := <-changes:
+			// Editors often save in several steps, wait for the burst to settle
+			pending[path] = true
+			debounce.Reset(iw.debounce)
+		case <-debounce.C:
+			iw.applyChanges(ctx, known, pending)
+			pending = map[string]bool{}
+		}
+	}
+}
+
+// generateAll renders every source whose outputs are not up to date, then
+// deletes outputs no source generates anymore. It returns the compile result
+// of every source found.
+func (iw *InputWatcher) generateAll(ctx context.Context) map[string]CompileResult {
+	results := map[string]CompileResult{}
+	var mu sync.Mutex
+
+	var wg sync.WaitGroup
+	for _, file := range iw.GetFiles(ctx) {
+		// Goroutines wait for a render slot, so only a limited number of renders run at once
+		wg.Go(func() {
+			result := iw.RegenerateIfNeeded(ctx, file)
+
+			mu.Lock()
+			results[file] = result
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	if ctx.Err() == nil {
+		iw.removeOrphans(ctx)
+	}
+
+	log.InfoContext(ctx, "initial generation finished", "files", len(results))
+	return results
+}
+
+// applyChanges regenerates changed sources and reconciles known with the
+// input folder when files or directories were added or removed. changed may
+// contain directories, standing for every file below them.
+func (iw *InputWatcher) applyChanges(ctx context.Context, known, changed map[string]bool) {
+	rescan := false
+	for path := range changed {
+		if !known[path] && !iw.includes.isIncluded(path) {
+			rescan = true
+			break
+		}
+		if _, err := os.Stat(path); err != nil && known[path] {
+			rescan = true
+			break
+		}
+	}
+
+	// Diagrams including a changed file are re-rendered along with it
+	dependents := map[string]bool{}
+	for _, file := range iw.dependentsOf(changed) {
+		dependents[file] = true
+	}
+	for _, file := range sortedKeys(changed) {
+		if _, err := os.Stat(file); err == nil || !iw.includes.isIncluded(file) {
+			continue
+		}
+
+		// A deleted include leaves the version of its dependents unchanged
+		for _, dependent := range iw.includes.dependents(file) {
+			iw.forgetCompileResult(dependent)
+		}
+	}
+
+	added := map[string]bool{}
+	if rescan {
+		files := iw.GetFiles(ctx)
+
+		addedDiagrams := []string{}
+		for _, file := range files {
+			if known[file] {
+				continue
+			}
+
+			log.InfoContext(ctx, "watching new file", "file", file)
+			iw.RegenerateIfNeeded(ctx, file)
+			addedDiagrams = append(addedDiagrams, iw.diagramsForInput(file)...)
+			known[file] = true
+			added[file] = true
+		}
+
+		// Detect deleted files and remove corresponding output files
+		removedDiagrams := []string{}
+		for _, file := range sortedKeys(known) {
+			if slices.Contains(files, file) {
+				continue
+			}
+
+			log.InfoContext(ctx, "file removed", "file", file)
+			removedDiagrams = append(removedDiagrams, iw.removeOutputs(ctx, file)...)
+			delete(known, file)
+		}
+
+		// A file that disappeared while another one showed up was most likely renamed
+		iw.publishMoves(ctx, removedDiagrams, addedDiagrams)
+	}
+
+	for _, file := range sortedKeys(known) {
+		if added[file] || (!changedUnder(changed, file) && !dependents[file]) {
+			continue
+		}
+
+		log.InfoContext(ctx, "file changed", "file", file)
+
+		// Keep watching while rendering so a newer save can cancel this render
+		iw.background.Go(func() { iw.RegenerateIfNeeded(ctx, file) })
+	}
+}
+
+// changedUnder reports whether file or one of its parent directories changed.
+func changedUnder(changed map[string]bool, file string) bool {
+	for path := range changed {
+		if path == file || strings.HasPrefix(file, path+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	return keys
+}
+
+// notify starts watching every directory of the input folder for file system
+// events and forwards relevant paths to changes.
+func (iw *InputWatcher) notify(ctx context.Context, changes chan<- string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	dirs := map[string]bool{}
+	if err := iw.watchDirs(watcher, iw.inputPath, dirs); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	log.InfoContext(ctx, "watching input folder for file system events", "directories", len(dirs))
+
+	// Directories outside the input folder holding included files
+	includeDirs := map[string]bool{}
+	iw.watchIncludeDirs(ctx, watcher, includeDirs)
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-iw.includes.updated:
+				iw.watchIncludeDirs(ctx, watcher, includeDirs)
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				if includeDirs[filepath.Dir(event.Name)] && !iw.inInputFolder(event.Name) {
+					if iw.includes.isIncluded(event.Name) {
+						sendChange(ctx, changes, event.Name)
+					}
+					continue
+				}
+
+				if path, ok := iw.eventPath(ctx, watcher, dirs, event); ok {
+					sendChange(ctx, changes, path)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+
+				// Events may have been dropped, look at the whole tree again
+				log.WarnContext(ctx, "file watcher error", "error", err)
+				sendChange(ctx, changes, iw.inputPath)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// eventPath returns the path an event is about if it may affect diagrams,
+// registering directories that were created or moved into the tree.
+func (iw *InputWatcher) eventPath(ctx context.Context, watcher *fsnotify.Watcher, dirs map[string]bool, event fsnotify.Event) (string, bool) {
+	if event.Has(fsnotify.Create) {
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := iw.watchDirs(watcher, event.Name, dirs); err != nil {
+				log.WarnContext(ctx, "failed to watch new directory", "directory", event.Name, "error", err)
+			}
+			return event.Name, true
+		}
+	}
+
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		if dirs[event.Name] {
+			for dir := range dirs {
+				if dir == event.Name || strings.HasPrefix(dir, event.Name+string(filepath.Separator)) {
+					delete(dirs, dir)
+				}
+			}
+			return event.Name, true
+		}
+	}
+
+	return event.Name, iw.isSourcePath(event.Name)
+}
+
+// watchDirs adds root and every directory below it to the watcher.
+func (iw *InputWatcher) watchDirs(watcher *fsnotify.Watcher, root string, dirs map[string]bool) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() {
+			return nil
+		}
+
+		// Generated files must not trigger renders when output lives inside
+		// input, hidden directories hold no sources either
+		if path != iw.inputPath && (path == iw.outputPath || strings.HasPrefix(d.Name(), ".")) {
+			return filepath.SkipDir
+		}
+
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("watch %s: %w", path, err)
+		}
+		dirs[path] = true
+
+		return nil
+	})
+}
+
+// watchIncludeDirs adds the directories of included files outside the input
+// folder to the watcher, and removes those no included file is left in.
+// watching holds the directories added so far.
+func (iw *InputWatcher) watchIncludeDirs(ctx context.Context, watcher *fsnotify.Watcher, watching map[string]bool) {
+	wanted := map[string]bool{}
+	for _, file := range iw.includes.included() {
+		if dir := filepath.Dir(file); !iw.inInputFolder(dir) {
+			wanted[dir] = true
+		}
+	}
+
+	for dir := range wanted {
+		if watching[dir] {
+			continue
+		}
+
+		// A missing directory is retried when the includes are scanned again
+		if err := watcher.Add(dir); err != nil {
+			log.DebugContext(ctx, "failed to watch include directory", "directory", dir, "error", err)
+			continue
+		}
+		watching[dir] = true
+		log.InfoContext(ctx, "watching include directory", "directory", dir)
+	}
+
+	for dir := range watching {
+		if wanted[dir] {
+			continue
+		}
+
+		if err := watcher.Remove(dir); err != nil {
+			log.DebugContext(ctx, "failed to stop watching include directory", "directory", dir, "error", err)
+		}
+		delete(watching, dir)
+	}
+}
+
+// inInputFolder reports whether path is the input folder or below it.
+func (iw *InputWatcher) inInputFolder(path string) bool {
+	return path == iw.inputPath || strings.HasPrefix(path, iw.inputPath+string(filepath.Separator))
+}
+
+// poll compares the source files and the files they include every interval
+// and forwards the paths that appeared, disappeared or changed to changes.
+func (iw *InputWatcher) poll(ctx context.Context, changes chan<- string, interval time.Duration) {
+	log.InfoContext(ctx, "polling input folder for changes", "interval", interval)
+
+	stamps := iw.stampFiles(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := iw.stampFiles(ctx)
+		for path, stamp := range current {
+			old, ok := stamps[path]
+			if !ok || old.size != stamp.size || !old.modTime.Equal(stamp.modTime) {
+				sendChange(ctx, changes, path)
+			}
+		}
+
+		for path := range stamps {
+			if _, ok := current[path]; !ok {
+				sendChange(ctx, changes, path)
+			}
+		}
+
+		stamps = current
+	}
+}
+
+func (iw *InputWatcher) stampFiles(ctx context.Context) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, file := range append(iw.GetFiles(ctx), iw.includes.included()...) {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+
+		stamps[file] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+	}
+
+	return stamps
+}
+
+func sendChange(ctx context.Context, changes chan<- string, path string) {
+	select {
+	case changes <- path:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,114 @@
+package inputwatcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForFile polls until path exists and contains want.
+func waitForFile(t *testing.T, path, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if content, err := os.ReadFile(path); err == nil && strings.Contains(string(content), want) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("%s never contained %q", path, want)
+}
+
+// runWatcher runs iw until the test ends and waits for it to stop, so nothing
+// is written to the temporary directories while they are removed.
+func runWatcher(t *testing.T, iw *InputWatcher) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		iw.Run(ctx)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func testRunRendersChanges(t *testing.T, mode WatchMode) {
+	iw, inputDir, outputDir := newTestWatcher(t, Options{
+		Watch:        mode,
+		PollInterval: 20 * time.Millisecond,
+		Debounce:     20 * time.Millisecond,
+	})
+	writeInput(t, filepath.Join(inputDir, "first.puml"), "@startuml\nfirst\n@enduml\n")
+
+	runWatcher(t, iw)
+
+	waitForFile(t, filepath.Join(outputDir, "first.svg"), "first")
+
+	// A new file in a new directory must be picked up recursively
+	nested := filepath.Join(inputDir, "nested", "deeper")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatalf("mkdir failed: %v", err)
+	}
+	writeInput(t, filepath.Join(nested, "second.puml"), "@startuml\nsecond\n@enduml\n")
+	waitForFile(t, filepath.Join(outputDir, "nested", "deeper", "second.svg"), "second")
+
+	writeInput(t, filepath.Join(inputDir, "first.puml"), "@startuml\nupdated\n@enduml\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(filepath.Join(inputDir, "first.puml"), later, later); err != nil {
+		t.Fatalf("chtimes failed: %v", err)
+	}
+	waitForFile(t, filepath.Join(outputDir, "first.svg"), "updated")
+}
+
+func TestRunRendersChangesWithEvents(t *testing.T) {
+	t.Parallel()
+	testRunRendersChanges(t, WatchNotify)
+}
+
+func TestRunRendersChangesWithPolling(t *testing.T) {
+	t.Parallel()
+	testRunRendersChanges(t, WatchPoll)
+}
+
+func TestRunRendersChangesInAutoMode(t *testing.T) {
+	t.Parallel()
+	testRunRendersChanges(t, WatchAuto)
+}
+
+func TestApplyChangesRemovesDeletedFiles(t *testing.T) {
+	t.Parallel()
+
+	iw, inputDir, outputDir := newTestWatcher(t, Options{})
+	input := filepath.Join(inputDir, "gone.puml")
+	writeInput(t, input, "@startuml\ngone\n@enduml\n")
+	iw.ExecuteAndTrack(context.Background(), input, outputDir)
+
+	events, unsubscribe := iw.Subscribe()
+	defer unsubscribe()
+
+	if err := os.Remove(input); err != nil {
+		t.Fatalf("remove failed: %v", err)
+	}
+	known := map[string]bool{input: true}
+	iw.applyChanges(context.Background(), known, map[string]bool{input: true})
+
+	if len(known) != 0 {
+		t.Fatalf("expected removed file to be forgotten, got %v", known)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "gone.svg")); !os.IsNotExist(err) {
+		t.Fatalf("expected output to be deleted, got %v", err)
+	}
+	if event := <-events; event.Type != EventDeleted || event.Diagram != "gone" {
+		t.Fatalf("unexpected event %#v", event)
+	}
+}
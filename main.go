@@ -11,14 +11,15 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
-	"strings"
-	"sync"
 	"time"
 
+	"github.com/mishankov/plantuml-watch-server/auth"
 	"github.com/mishankov/plantuml-watch-server/config"
 	"github.com/mishankov/plantuml-watch-server/handlers"
 	"github.com/mishankov/plantuml-watch-server/inputwatcher"
 	"github.com/mishankov/plantuml-watch-server/plantuml"
+	"github.com/mishankov/plantuml-watch-server/rendercache"
+	"github.com/mishankov/plantuml-watch-server/renderqueue"
 	"github.com/platforma-dev/platforma/application"
 	"github.com/platforma-dev/platforma/httpserver"
 	"github.com/platforma-dev/platforma/log"
@@ -30,21 +31,6 @@ var staticFiles embed.FS
 //go:embed templates
 var templateFiles embed.FS
 
-func calculateOutputDirForFile(ctx context.Context, inputFilePath, inputRoot, outputRoot string) string {
-	relPath, err := filepath.Rel(inputRoot, inputFilePath)
-	if err != nil {
-		log.ErrorContext(ctx, "error calculating relative path", "path", inputFilePath, "error", err)
-		return outputRoot
-	}
-
-	relDir := filepath.Dir(relPath)
-	if relDir == "." {
-		return outputRoot
-	}
-
-	return filepath.Join(outputRoot, relDir)
-}
-
 func main() {
 	ctx := context.Background()
 	app := application.New()
@@ -54,60 +40,152 @@ func main() {
 		if errors.Is(err, flag.ErrHelp) {
 			return
 		}
+		exitOnUnknownCommand(err)
 		log.ErrorContext(ctx, "failed to load config", "error", err)
 		return
 	}
 
-	puml := plantuml.New(config.PlantUMLPath)
-	iw := inputwatcher.New(config.InputFolder, config.OutputFolder, puml)
+	switch config.Command {
+	case "build":
+		os.Exit(runBuild(ctx, config))
+	case "export":
+		os.Exit(runExport(ctx, config))
+	}
+
+	renderer := newRenderer(config)
+	if puml, ok := renderer.(*plantuml.PlantUML); ok {
+		app.RegisterService("plantuml workers", puml)
+	}
+
+	cache, err := openCache(config)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to open render cache", "error", err)
+		return
+	}
+
+	queue := renderqueue.New(config.Concurrency)
+	iw := newInputWatcher(config, renderer, queue, cache)
 
 	// Preparing termplates
-	tmpls, err := template.New("").ParseFS(templateFiles, "templates/*.html")
+	tmpls, err := parseTemplates()
 	if err != nil {
 		log.ErrorContext(ctx, "failed to parse templates", "error", err)
 		return
 	}
 
 	app.OnStartFunc(func(ctx context.Context) error {
-		// Remove all stale outputs
-		os.RemoveAll(config.OutputFolder + "/")
-
-		// Generate initial SVGs - iterate through each file to preserve structure
-		inputPattern := config.InputFolder + "/**.puml"
-		files, err := filepath.Glob(inputPattern)
-		if err != nil {
-			return fmt.Errorf("Error finding .puml files: %w", err)
-		}
-
-		var wg sync.WaitGroup
-		for _, file := range files {
-			// Skip files prefixed with underscore
-			if strings.HasPrefix(filepath.Base(file), "_") {
-				continue
-			}
-
-			outputDir := calculateOutputDirForFile(ctx, file, config.InputFolder, config.OutputFolder)
-			wg.Go(func() { iw.ExecuteAndTrack(ctx, file, outputDir) })
-		}
-
-		wg.Wait()
+		// Outputs of unchanged sources are reused, the rest is rendered in the background
+		return iw.LoadState(ctx)
+	}, application.StartupTaskConfig{Name: "restore state", AbortOnError: true})
 
-		return nil
-	}, application.StartupTaskConfig{Name: "initial generation", AbortOnError: true})
+	authenticator, err := auth.New(config.Htpasswd, config.TokenFile)
+	if err != nil {
+		log.ErrorContext(ctx, "failed to load credentials", "error", err)
+		return
+	}
 
 	server := httpserver.New(strconv.Itoa(config.Port), 3*time.Second)
+	if config.Auth == "all" {
+		server.UseFunc(authenticator.Require)
+	}
+
+	server.Handle("/output/{name...}", handlers.NewSvgViewHandler(config.OutputFolder, tmpls, config.Formats, config.ReadOnly, iw))
+	svgWSHandler := handlers.NewSVGWSHandler(config.OutputFolder, iw)
+	server.Handle("/ws/{name...}", svgWSHandler)
+	server.Handle("/download/{name...}", handlers.NewDownloadHandler(iw))
+	var sourceHandler http.Handler = handlers.NewSourceHandler(iw, config.ReadOnly)
+	if authenticator.Enabled() {
+		sourceHandler = authenticator.RequireForWrites(sourceHandler)
+	}
+	server.Handle("/source/{name...}", sourceHandler)
+	var backupsHandler http.Handler = handlers.NewBackupsHandler(iw, config.ReadOnly)
+	if authenticator.Enabled() {
+		backupsHandler = authenticator.RequireForWrites(backupsHandler)
+	}
+	server.Handle("/backups/{name...}", backupsHandler)
+	diagramsAPIHandler := handlers.NewDiagramsAPIHandler(iw)
+	server.Handle("/api/diagrams", diagramsAPIHandler)
+	server.Handle("/api/diagrams/{name...}", diagramsAPIHandler)
+	server.Handle("/api/history/{name...}", handlers.NewHistoryAPIHandler(iw))
+	server.Handle("/diff", handlers.NewDiffHandler(iw, tmpls))
+	server.Handle("/api/diff", handlers.NewDiffAPIHandler(iw))
+
+	// PlantUML server compatible endpoints for IDE plugins and Markdown previews
+	renderHandler := handlers.NewRenderHandler(newAPIRenderer(app, config, renderer), queue, cache, config.RenderTimeout)
+	for _, format := range plantuml.Formats() {
+		if format.ServerPath != "" {
+			server.Handle("/"+format.ServerPath+"/{encoded}", renderHandler)
+		}
+	}
+	server.Handle("/render", renderHandler)
 
-	server.Handle("/output/{name...}", handlers.NewSvgViewHandler(config.OutputFolder, tmpls))
-	server.Handle("/ws/{name...}", handlers.NewSVGWSHandler(config.OutputFolder))
-	server.Handle("/download/{name...}", handlers.NewDownloadHandler(config.OutputFolder))
-	server.Handle("/source/{name...}", handlers.NewSourceHandler(iw))
 	server.Handle("/static/{file}", http.FileServer(http.FS(staticFiles)))
 	server.Handle("/", handlers.NewIndexHandler(config.OutputFolder, tmpls))
 
 	app.RegisterService("file watcher", iw)
+	app.RegisterService("diagram updates", svgWSHandler)
 	app.RegisterService("server", server)
 
 	if err := app.Run(ctx); err != nil {
 		log.InfoContext(ctx, "application exited", "error", err)
 	}
 }
+
+// exitOnUnknownCommand prints the usage and exits with status 2 if err is
+// about an unknown subcommand.
+func exitOnUnknownCommand(err error) {
+	if errors.Is(err, config.ErrUnknownCommand) {
+		fmt.Fprintf(os.Stderr, "%v\n%s\n", err, config.Usage)
+		os.Exit(2)
+	}
+}
+
+func parseTemplates() (*template.Template, error) {
+	return template.New("").Funcs(handlers.ServerLinks()).ParseFS(templateFiles, "templates/*.html")
+}
+
+// newRenderer creates the configured renderer. Worker processes of the jar
+// renderer are stopped when its Run returns.
+func newRenderer(config *config.Config) plantuml.Renderer {
+	if config.Renderer == "http" {
+		return plantuml.NewHTTPRenderer(config.RendererURL)
+	}
+
+	return plantuml.New(config.PlantUMLPath, config.Workers)
+}
+
+// newAPIRenderer returns the renderer for diagram text sent to the render
+// API. Anybody may send it, so the jar renderer gets sandboxed workers of its
+// own. A PlantUML server applies its own security profile.
+func newAPIRenderer(app *application.Application, config *config.Config, renderer plantuml.Renderer) plantuml.Renderer {
+	if _, ok := renderer.(*plantuml.PlantUML); !ok {
+		return renderer
+	}
+
+	sandboxed := plantuml.NewSandboxed(config.PlantUMLPath, config.Workers)
+	app.RegisterService("sandboxed plantuml workers", sandboxed)
+	return sandboxed
+}
+
+// openCache opens the render cache in the data directory, nil if disabled.
+func openCache(config *config.Config) (*rendercache.Cache, error) {
+	if config.CacheSize <= 0 {
+		return nil, nil
+	}
+
+	cacheDir := filepath.Join(config.OutputFolder, inputwatcher.DataDirName, "cache")
+	return rendercache.New(cacheDir, int64(config.CacheSize)<<20)
+}
+
+func newInputWatcher(config *config.Config, renderer plantuml.Renderer, queue *renderqueue.Queue, cache *rendercache.Cache) *inputwatcher.InputWatcher {
+	return inputwatcher.New(config.InputFolder, config.OutputFolder, renderer, inputwatcher.Options{
+		Queue:         queue,
+		RenderTimeout: config.RenderTimeout,
+		Cache:         cache,
+		Watch:         inputwatcher.WatchMode(config.Watch),
+		PollInterval:  config.PollInterval,
+		Debounce:      config.Debounce,
+		Backups:       config.Backups,
+		History:       config.History,
+	})
+}
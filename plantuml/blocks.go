@@ -0,0 +1,102 @@
+package plantuml
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Block is a single @start.../@end... diagram inside a source file.
+type Block struct {
+	// Name is the output name given after the @start tag, if any.
+	Name string
+	// Source is the diagram text including the @start and @end lines.
+	Source string
+	// StartLine is the 1-based line of the @start tag in the source file.
+	StartLine int
+}
+
+// SplitBlocks returns the diagrams of a source file in order of appearance.
+// Text outside @start/@end pairs is ignored, like PlantUML does for files.
+func SplitBlocks(source string) []Block {
+	blocks, _ := splitBlocks(source)
+	return blocks
+}
+
+// splitBlocks is SplitBlocks reporting a last @start line that is never
+// closed. The error is on the line of the source, not relative to a block.
+func splitBlocks(source string) ([]Block, *blockError) {
+	blocks := []Block{}
+	lines := strings.Split(strings.ReplaceAll(source, "\r\n", "\n"), "\n")
+
+	var current *Block
+	var body []string
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+
+		if current == nil {
+			if strings.HasPrefix(trimmed, "@start") {
+				current = &Block{Name: blockName(trimmed), StartLine: i + 1}
+				body = []string{line}
+			}
+			continue
+		}
+
+		body = append(body, line)
+		if strings.HasPrefix(trimmed, "@end") {
+			current.Source = strings.Join(body, "\n") + "\n"
+			blocks = append(blocks, *current)
+			current = nil
+		}
+	}
+
+	if current != nil {
+		tag, _, _ := strings.Cut(strings.TrimSpace(body[0]), " ")
+		tag, _, _ = strings.Cut(tag, "(")
+		return blocks, &blockError{Line: current.StartLine, Message: "No @end line closing " + tag + ", expected @end" + strings.TrimPrefix(tag, "@start")}
+	}
+
+	return blocks, nil
+}
+
+// blockName extracts the file name from a "@startuml name" line.
+func blockName(startLine string) string {
+	_, rest, found := strings.Cut(startLine, " ")
+	if !found {
+		return ""
+	}
+
+	rest = strings.TrimSpace(rest)
+	if strings.HasPrefix(rest, "(") {
+		// @startuml(id=...) style attributes are not file names
+		return ""
+	}
+
+	return strings.Trim(rest, `"`)
+}
+
+// OutputFileNames returns the output file names PlantUML uses for blocks of
+// inputFile in the given format: named blocks use their name, unnamed ones use
+// the input base name with a _001, _002, ... suffix after the first.
+func OutputFileNames(inputFile string, blocks []Block, format string) []string {
+	ext := "." + FormatExtension(format)
+	base := strings.TrimSuffix(filepath.Base(inputFile), filepath.Ext(inputFile))
+
+	names := make([]string, 0, len(blocks))
+	unnamed := 0
+	for _, block := range blocks {
+		if block.Name != "" {
+			names = append(names, filepath.Clean(strings.TrimSuffix(block.Name, ext)+ext))
+			continue
+		}
+
+		if unnamed == 0 {
+			names = append(names, base+ext)
+		} else {
+			names = append(names, fmt.Sprintf("%s_%03d%s", base, unnamed, ext))
+		}
+		unnamed++
+	}
+
+	return names
+}
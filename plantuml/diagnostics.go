@@ -0,0 +1,93 @@
+package plantuml
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+var (
+	errorLinePattern = regexp.MustCompile(`^Error line (\d+) in file: (.+)$`)
+	warningPattern   = regexp.MustCompile(`^Warning(?: line (\d+) in file: (.+)|: (.+))$`)
+)
+
+// Diagnostic is a single problem PlantUML reported for a source file.
+type Diagnostic struct {
+	File string `json:"file,omitempty"`
+	// Line is 1-based; 0 means the problem is not tied to a line.
+	Line     int    `json:"line,omitempty"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+	// Context is the text of the offending source line, if known.
+	Context string `json:"context,omitempty"`
+}
+
+// ParseDiagnostics extracts diagnostics from renderer output. Each
+// "Error line N in file: X" header starts a diagnostic and the lines up to
+// the next header form its message. Output without any header becomes a
+// single diagnostic that isn't tied to a line.
+func ParseDiagnostics(output string) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	var current *Diagnostic
+	var message []string
+
+	flush := func() {
+		if current == nil {
+			return
+		}
+		current.Message = strings.Join(message, "\n")
+		if current.Message == "" {
+			current.Message = "Syntax error"
+		}
+		diagnostics = append(diagnostics, *current)
+		current = nil
+		message = nil
+	}
+
+	var unmatched []string
+	for _, line := range strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if match := errorLinePattern.FindStringSubmatch(trimmed); match != nil {
+			flush()
+			lineNumber, _ := strconv.Atoi(match[1])
+			current = &Diagnostic{File: match[2], Line: lineNumber, Severity: SeverityError}
+			continue
+		}
+
+		if match := warningPattern.FindStringSubmatch(trimmed); match != nil {
+			flush()
+			lineNumber, _ := strconv.Atoi(match[1])
+			current = &Diagnostic{File: match[2], Line: lineNumber, Severity: SeverityWarning}
+			if match[3] != "" {
+				message = []string{match[3]}
+			}
+			continue
+		}
+
+		if trimmed == "" {
+			continue
+		}
+
+		if current != nil {
+			message = append(message, trimmed)
+		} else {
+			unmatched = append(unmatched, trimmed)
+		}
+	}
+	flush()
+
+	if len(diagnostics) == 0 && len(unmatched) > 0 {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Message:  strings.Join(unmatched, "\n"),
+		})
+	}
+
+	return diagnostics
+}
@@ -0,0 +1,62 @@
+package plantuml
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// textEncoding is the base64 variant used in PlantUML server URLs.
+var textEncoding = base64.NewEncoding("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-_").WithPadding(base64.NoPadding)
+
+// Encode compresses diagram text the way PlantUML server URLs expect:
+// raw deflate followed by PlantUML's URL-safe base64 alphabet.
+func Encode(source string) (string, error) {
+	var compressed bytes.Buffer
+	writer, err := flate.NewWriter(&compressed, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := writer.Write([]byte(source)); err != nil {
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		return "", err
+	}
+
+	return textEncoding.EncodeToString(compressed.Bytes()), nil
+}
+
+// Decode reverses Encode. It also accepts the "~h" hex form PlantUML servers
+// support.
+func Decode(encoded string) (string, error) {
+	if hexText, ok := strings.CutPrefix(encoded, "~h"); ok {
+		text, err := hex.DecodeString(hexText)
+		if err != nil {
+			return "", fmt.Errorf("decode hex diagram: %w", err)
+		}
+		return string(text), nil
+	}
+
+	// "~1" marks the deflate encoding explicitly
+	encoded = strings.TrimPrefix(encoded, "~1")
+
+	// Encoders following PlantUML's reference implementation always emit full
+	// four character groups, so the final group may carry filler bits.
+	compressed, err := textEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return "", fmt.Errorf("decode diagram: %w", err)
+	}
+
+	text, err := io.ReadAll(flate.NewReader(bytes.NewReader(compressed)))
+	if err != nil {
+		return "", fmt.Errorf("inflate diagram: %w", err)
+	}
+
+	return string(text), nil
+}
@@ -0,0 +1,63 @@
+package plantuml
+
+// Format is an output format PlantUML can render.
+type Format struct {
+	// Name identifies the format in options and download links.
+	Name string
+	// Label is shown to users.
+	Label string
+	// Flag selects the format on the PlantUML command line.
+	Flag string
+	// Extension is the file extension PlantUML writes.
+	Extension string
+	// ContentType is sent when the file is downloaded.
+	ContentType string
+	// ServerPath is the PlantUML server endpoint rendering the format, empty
+	// if the server can't.
+	ServerPath string
+}
+
+var formats = []Format{
+	{Name: "svg", Label: "SVG", Flag: "-tsvg", Extension: "svg", ContentType: "image/svg+xml", ServerPath: "svg"},
+	{Name: "png", Label: "PNG", Flag: "-tpng", Extension: "png", ContentType: "image/png", ServerPath: "png"},
+	{Name: "pdf", Label: "PDF", Flag: "-tpdf", Extension: "pdf", ContentType: "application/pdf", ServerPath: "pdf"},
+	{Name: "eps", Label: "EPS", Flag: "-teps", Extension: "eps", ContentType: "application/postscript", ServerPath: "eps"},
+	{Name: "latex", Label: "LaTeX", Flag: "-tlatex", Extension: "tex", ContentType: "application/x-tex"},
+	{Name: "txt", Label: "ASCII", Flag: "-ttxt", Extension: "atxt", ContentType: "text/plain; charset=utf-8", ServerPath: "txt"},
+	{Name: "utxt", Label: "Unicode ASCII", Flag: "-tutxt", Extension: "utxt", ContentType: "text/plain; charset=utf-8"},
+	{Name: "xmi", Label: "XMI", Flag: "-txmi", Extension: "xmi", ContentType: "application/vnd.xmi+xml"},
+}
+
+// Formats returns every supported output format, SVG first.
+func Formats() []Format {
+	return append([]Format(nil), formats...)
+}
+
+// LookupFormat returns the format called name.
+func LookupFormat(name string) (Format, bool) {
+	for _, format := range formats {
+		if format.Name == name {
+			return format, true
+		}
+	}
+
+	return Format{}, false
+}
+
+// formatFlag maps format to the PlantUML command line flag.
+func formatFlag(format string) (string, bool) {
+	if f, ok := LookupFormat(format); ok {
+		return f.Flag, true
+	}
+
+	return "-tsvg", false
+}
+
+// FormatExtension returns the file extension PlantUML uses for format.
+func FormatExtension(format string) string {
+	if f, ok := LookupFormat(format); ok {
+		return f.Extension
+	}
+
+	return "svg"
+}
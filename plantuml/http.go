@@ -0,0 +1,102 @@
+package plantuml
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HTTPRenderer renders diagrams with a remote PlantUML server.
+type HTTPRenderer struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewHTTPRenderer(baseURL string) *HTTPRenderer {
+	return &HTTPRenderer{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		client:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Version identifies the server. Its PlantUML release is not known, so
+// upgrading the server needs a cache reset.
+func (r *HTTPRenderer) Version() string {
+	return "http:" + r.baseURL
+}
+
+// ExecuteWithFormat renders every diagram of input on the server. Local
+// includes are inlined first since the server can't read them.
+func (r *HTTPRenderer) ExecuteWithFormat(ctx context.Context, input, output, format string) (string, error) {
+	f, ok := LookupFormat(format)
+	if !ok || f.ServerPath == "" {
+		err := fmt.Errorf("format %q is not supported by the PlantUML server renderer", format)
+		return err.Error(), err
+	}
+
+	if err := os.MkdirAll(output, 0755); err != nil {
+		return "", err
+	}
+
+	inputDir := filepath.Dir(input)
+
+	return renderBlocks(ctx, input, output, format, func(ctx context.Context, block Block) ([]byte, *blockError, error) {
+		source, lines, err := InlineIncludesWithLines(block.Source, inputDir, os.ReadFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("resolve includes: %w", err)
+		}
+
+		image, blockErr, err := r.render(ctx, source, f)
+		if blockErr != nil {
+			// The server counts the inlined lines
+			blockErr.mapLine(lines)
+		}
+
+		return image, blockErr, err
+	})
+}
+
+func (r *HTTPRenderer) render(ctx context.Context, source string, format Format) ([]byte, *blockError, error) {
+	encoded, err := Encode(source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("encode diagram: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.baseURL+"/"+format.ServerPath+"/"+encoded, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read plantuml server response: %w", err)
+	}
+
+	// The server answers syntax errors with an error image and describes the
+	// error in headers.
+	if message := resp.Header.Get("X-PlantUML-Diagram-Error"); message != "" {
+		line, err := strconv.Atoi(resp.Header.Get("X-PlantUML-Diagram-Error-Line"))
+		if err != nil {
+			line = 1
+		}
+		return body, &blockError{Line: line, Message: message}, nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("plantuml server returned %s", resp.Status)
+	}
+
+	return body, nil, nil
+}
@@ -0,0 +1,189 @@
+package plantuml
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	source := "@startuml\nAlice -> Bob: héllo\n@enduml\n"
+
+	encoded, err := Encode(source)
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	if strings.ContainsAny(encoded, "+/=") {
+		t.Fatalf("encoded text is not URL safe: %q", encoded)
+	}
+
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded != source {
+		t.Fatalf("round trip mismatch: got %q want %q", decoded, source)
+	}
+
+	// Reference encoders pad the last group to four characters
+	if decoded, err := Decode(encoded + "0"); err != nil || decoded != source {
+		t.Fatalf("expected padded text to decode, got %q (err %v)", decoded, err)
+	}
+
+	if decoded, err := Decode("~h" + "407374617274756d6c"); err != nil || decoded != "@startuml" {
+		t.Fatalf("unexpected hex decode %q (err %v)", decoded, err)
+	}
+}
+
+func TestHTTPRendererWritesOutputsAndReportsErrors(t *testing.T) {
+	t.Parallel()
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	writeFile(t, filepath.Join(inputDir, "_style.puml"), "skinparam shadowing false\n")
+	input := filepath.Join(inputDir, "flow.puml")
+	writeFile(t, input, "@startuml\n!include _style.puml\nA -> B\n@enduml\n\n@startuml broken\nA -> \n@enduml\n")
+
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format, encoded, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/plantuml/"), "/")
+		source, err := Decode(encoded)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		requested = append(requested, format+":"+source)
+
+		if strings.Contains(source, "A -> \n") {
+			w.Header().Set("X-PlantUML-Diagram-Error", "Syntax Error?")
+			w.Header().Set("X-PlantUML-Diagram-Error-Line", "2")
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("<svg>error</svg>"))
+			return
+		}
+
+		_, _ = w.Write([]byte("<svg>ok</svg>"))
+	}))
+	defer server.Close()
+
+	renderer := NewHTTPRenderer(server.URL + "/plantuml/")
+	message, err := renderer.ExecuteWithFormat(context.Background(), input, outputDir, "svg")
+	if err == nil {
+		t.Fatal("expected error for broken diagram")
+	}
+	if want := "Error line 7 in file: " + input + "\nSyntax Error?"; message != want {
+		t.Fatalf("unexpected message:\ngot  %q\nwant %q", message, want)
+	}
+
+	if len(requested) != 2 || requested[0] != "svg:@startuml\nskinparam shadowing false\nA -> B\n@enduml\n" {
+		t.Fatalf("unexpected requests: %q", requested)
+	}
+
+	assertFileContent(t, filepath.Join(outputDir, "flow.svg"), "<svg>ok</svg>")
+	assertFileContent(t, filepath.Join(outputDir, "broken.svg"), "<svg>error</svg>")
+}
+
+func TestHTTPRendererMapsErrorLinesPastIncludes(t *testing.T) {
+	t.Parallel()
+
+	inputDir := t.TempDir()
+	include := filepath.Join(inputDir, "_style.puml")
+	writeFile(t, include, "skinparam shadowing false\nskinparam monochrome true\nskinparam handwritten true\n")
+	input := filepath.Join(inputDir, "flow.puml")
+	writeFile(t, input, "' title\n@startuml\n!include _style.puml\nA -> B\nA -> \n@enduml\n")
+
+	// The server reports the line of the inlined text it received, as
+	// PlantUML servers do
+	errorLine := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, encoded, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		source, err := Decode(encoded)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		for i, line := range strings.Split(source, "\n") {
+			if line == errorLine {
+				w.Header().Set("X-PlantUML-Diagram-Error", "Syntax Error?")
+				w.Header().Set("X-PlantUML-Diagram-Error-Line", strconv.Itoa(i+1))
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte("<svg>error</svg>"))
+				return
+			}
+		}
+		_, _ = w.Write([]byte("<svg>ok</svg>"))
+	}))
+	defer server.Close()
+
+	renderer := NewHTTPRenderer(server.URL)
+	for line, want := range map[string]string{
+		// Line 5 of flow.puml, although it is line 6 of the inlined diagram
+		"A -> ": "Error line 5 in file: " + input,
+		// Errors in the included file point into it
+		"skinparam monochrome true": "Error line 2 in file: " + include,
+	} {
+		errorLine = line
+		message, err := renderer.ExecuteWithFormat(context.Background(), input, t.TempDir(), "svg")
+		if err == nil {
+			t.Fatalf("expected an error for %q", line)
+		}
+		if want += "\nSyntax Error?"; message != want {
+			t.Fatalf("unexpected message:\ngot  %q\nwant %q", message, want)
+		}
+	}
+}
+
+func TestHTTPRendererReportsUnclosedBlocks(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("<svg>ok</svg>"))
+	}))
+	defer server.Close()
+
+	input := filepath.Join(t.TempDir(), "flow.puml")
+	writeFile(t, input, "@startuml\nA -> B\n@enduml\n\n@startmindmap(id=map)\n* root\n")
+
+	output := t.TempDir()
+	message, err := NewHTTPRenderer(server.URL).ExecuteWithFormat(context.Background(), input, output, "svg")
+	if err == nil {
+		t.Fatal("expected the unclosed diagram to fail the render")
+	}
+	if want := "Error line 5 in file: " + input + "\nNo @end line closing @startmindmap, expected @endmindmap"; message != want {
+		t.Fatalf("unexpected message:\ngot  %q\nwant %q", message, want)
+	}
+
+	// The diagrams before it are rendered anyway
+	if _, err := os.Stat(filepath.Join(output, "flow.svg")); err != nil {
+		t.Fatalf("expected the closed diagram to be rendered: %v", err)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("write %s failed: %v", path, err)
+	}
+}
+
+func assertFileContent(t *testing.T, path, want string) {
+	t.Helper()
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read %s failed: %v", path, err)
+	}
+	if string(got) != want {
+		t.Fatalf("unexpected content of %s: got %q want %q", path, got, want)
+	}
+}
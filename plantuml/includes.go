@@ -0,0 +1,272 @@
+package plantuml
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var includeDirective = regexp.MustCompile(`^(\s*)(!include_many|!include_once|!includesub|!include|!import)(\s+)(\S.*?)\s*$`)
+
+// Include is a file reference made by an !include-style directive.
+type Include struct {
+	Directive string
+	// Path is the referenced file without any !PART or !index suffix.
+	Path string
+	// Suffix is the "!PART" selector following the path, if any.
+	Suffix string
+	// Line is the 1-based line of the directive.
+	Line int
+}
+
+// IsLocal reports whether the include refers to a file on disk rather than
+// the standard library or a URL.
+func (inc Include) IsLocal() bool {
+	return !strings.HasPrefix(inc.Path, "<") && !strings.Contains(inc.Path, "://")
+}
+
+// ParseIncludes returns every !include, !include_many, !include_once,
+// !includesub and !import directive in source.
+func ParseIncludes(source string) []Include {
+	includes := []Include{}
+	for i, line := range strings.Split(source, "\n") {
+		match := includeDirective.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+
+		path, suffix := splitIncludeSuffix(match[4])
+		includes = append(includes, Include{
+			Directive: match[2],
+			Path:      path,
+			Suffix:    suffix,
+			Line:      i + 1,
+		})
+	}
+
+	return includes
+}
+
+// ResolveInclude returns the absolute path of a local include relative to dir.
+func ResolveInclude(dir string, inc Include) (string, bool) {
+	if !inc.IsLocal() {
+		return "", false
+	}
+
+	if filepath.IsAbs(inc.Path) {
+		return filepath.Clean(inc.Path), true
+	}
+
+	return filepath.Join(dir, filepath.FromSlash(inc.Path)), true
+}
+
+// AbsoluteIncludes rewrites relative local includes in source to absolute
+// paths based on dir. PlantUML resolves includes of diagrams read from stdin
+// against its working directory, so sources fed to a long-lived process need
+// this to behave as if they were rendered from their own file.
+func AbsoluteIncludes(source, dir string) string {
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		match := includeDirective.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil {
+			continue
+		}
+
+		path, suffix := splitIncludeSuffix(match[4])
+		resolved, ok := ResolveInclude(dir, Include{Path: path})
+		if !ok {
+			continue
+		}
+
+		lines[i] = match[1] + match[2] + match[3] + filepath.ToSlash(resolved) + suffix
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func splitIncludeSuffix(value string) (string, string) {
+	value = strings.Trim(value, `"`)
+	if strings.HasPrefix(value, "<") {
+		return value, ""
+	}
+
+	if idx := strings.LastIndex(value, "!"); idx > 0 {
+		return value[:idx], value[idx:]
+	}
+
+	return value, ""
+}
+
+// maxIncludeDepth guards against include cycles when inlining.
+const maxIncludeDepth = 32
+
+// ReadFileFunc reads an included file by absolute path.
+type ReadFileFunc func(path string) ([]byte, error)
+
+// SourceLine is where a line of inlined source came from.
+type SourceLine struct {
+	// File is the included file the line was read from, empty for lines of
+	// the source itself.
+	File string
+	// Line is 1-based.
+	Line int
+}
+
+// InlineIncludes replaces local include directives in source with the
+// content they refer to, recursively, so the diagram can be rendered where
+// the files are not available. dir is the directory of the source file.
+// !import directives and standard library or URL includes are kept as-is.
+func InlineIncludes(source, dir string, readFile ReadFileFunc) (string, error) {
+	content, _, err := InlineIncludesWithLines(source, dir, readFile)
+	return content, err
+}
+
+// InlineIncludesWithLines is InlineIncludes that also returns where every
+// line of the result came from, so errors reported for the inlined source can
+// be traced back to the files.
+func InlineIncludesWithLines(source, dir string, readFile ReadFileFunc) (string, []SourceLine, error) {
+	lines := strings.Split(source, "\n")
+	origins := make([]SourceLine, len(lines))
+	for i := range lines {
+		origins[i] = SourceLine{Line: i + 1}
+	}
+
+	lines, origins, err := inlineIncludes(lines, origins, dir, readFile, map[string]bool{}, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return strings.Join(lines, "\n"), origins, nil
+}
+
+func inlineIncludes(lines []string, origins []SourceLine, dir string, readFile ReadFileFunc, included map[string]bool, depth int) ([]string, []SourceLine, error) {
+	if depth > maxIncludeDepth {
+		return nil, nil, fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+	}
+
+	out := make([]string, 0, len(lines))
+	outOrigins := make([]SourceLine, 0, len(lines))
+	for i, line := range lines {
+		match := includeDirective.FindStringSubmatch(strings.TrimRight(line, "\r"))
+		if match == nil || match[2] == "!import" {
+			out = append(out, line)
+			outOrigins = append(outOrigins, origins[i])
+			continue
+		}
+
+		path, suffix := splitIncludeSuffix(match[4])
+		inc := Include{Directive: match[2], Path: path, Suffix: suffix, Line: origins[i].Line}
+		resolved, ok := ResolveInclude(dir, inc)
+		if !ok {
+			out = append(out, line)
+			outOrigins = append(outOrigins, origins[i])
+			continue
+		}
+
+		if inc.Directive == "!include_once" && included[resolved] {
+			continue
+		}
+		included[resolved] = true
+
+		content, err := readFile(resolved)
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: %w", inc.Line, err)
+		}
+
+		body, bodyLines := includedBody(string(content), inc)
+		bodyOrigins := make([]SourceLine, len(bodyLines))
+		for j, n := range bodyLines {
+			bodyOrigins[j] = SourceLine{File: resolved, Line: n}
+		}
+
+		body, bodyOrigins, err = inlineIncludes(body, bodyOrigins, filepath.Dir(resolved), readFile, included, depth+1)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", inc.Path, err)
+		}
+
+		// Trailing blank lines are dropped, the directive's line is kept
+		end := len(body)
+		for end > 1 && body[end-1] == "" {
+			end--
+		}
+		if end == 0 {
+			body, bodyOrigins, end = []string{""}, []SourceLine{origins[i]}, 1
+		}
+
+		out = append(out, body[:end]...)
+		outOrigins = append(outOrigins, bodyOrigins[:end]...)
+	}
+
+	return out, outOrigins, nil
+}
+
+// includedBody picks the lines of an included file a directive refers to,
+// along with their 1-based line numbers in the file.
+func includedBody(content string, inc Include) ([]string, []int) {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	selector := strings.TrimPrefix(inc.Suffix, "!")
+
+	if inc.Directive == "!includesub" {
+		return subpart(lines, selector)
+	}
+
+	blocks := SplitBlocks(content)
+	if len(blocks) == 0 {
+		return lines, lineRange(1, len(lines))
+	}
+
+	index := 0
+	if selector != "" {
+		if n, err := strconv.Atoi(selector); err == nil {
+			index = n
+		} else {
+			for i, block := range blocks {
+				if block.Name == selector {
+					index = i
+				}
+			}
+		}
+	}
+	if index < 0 || index >= len(blocks) {
+		index = 0
+	}
+
+	// Drop the @start and @end lines of the included diagram
+	block := blocks[index]
+	blockLines := strings.Split(strings.TrimRight(block.Source, "\n"), "\n")
+	return blockLines[1 : len(blockLines)-1], lineRange(block.StartLine+1, len(blockLines)-2)
+}
+
+// subpart returns the lines between "!startsub name" and "!endsub" with
+// their line numbers.
+func subpart(lines []string, name string) ([]string, []int) {
+	var out []string
+	var numbers []int
+	inside := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "!startsub") && strings.TrimSpace(strings.TrimPrefix(trimmed, "!startsub")) == name:
+			inside = true
+		case strings.HasPrefix(trimmed, "!endsub"):
+			inside = false
+		case inside:
+			out = append(out, line)
+			numbers = append(numbers, i+1)
+		}
+	}
+
+	return out, numbers
+}
+
+// lineRange returns the line numbers of count lines starting at first.
+func lineRange(first, count int) []int {
+	numbers := make([]int, count)
+	for i := range numbers {
+		numbers[i] = first + i
+	}
+
+	return numbers
+}
@@ -0,0 +1,141 @@
+package plantuml
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxScale = 10
+	maxDPI   = 1200
+)
+
+// Themes lists PlantUML's built-in themes offered for downloads.
+var Themes = []string{
+	"amiga",
+	"aws-orange",
+	"blueprint",
+	"cerulean",
+	"cerulean-outline",
+	"crt-amber",
+	"crt-green",
+	"mars",
+	"materia",
+	"materia-outline",
+	"metal",
+	"mimeograph",
+	"minty",
+	"plain",
+	"reddress-darkblue",
+	"reddress-lightblue",
+	"sandstone",
+	"silver",
+	"sketchy",
+	"sketchy-outline",
+	"spacelab",
+	"superhero",
+	"toy",
+	"united",
+	"vibrant",
+}
+
+// RenderOptions change how a diagram is drawn without touching its source.
+// The zero value renders the diagram as written.
+type RenderOptions struct {
+	// Scale multiplies the size of the diagram, e.g. 2 for a 2x PNG.
+	Scale float64
+	// DPI sets the resolution of raster formats.
+	DPI int
+	// Theme is a built-in PlantUML theme name.
+	Theme string
+}
+
+// ParseRenderOptions reads render options from their text form, as found in
+// a query string. Empty values keep the default.
+func ParseRenderOptions(scale, dpi, theme string) (RenderOptions, error) {
+	opts := RenderOptions{Theme: theme}
+
+	if scale != "" {
+		value, err := strconv.ParseFloat(scale, 64)
+		if err != nil || value <= 0 || value > maxScale {
+			return RenderOptions{}, fmt.Errorf("scale must be a number between 0 and %d", maxScale)
+		}
+		if value != 1 {
+			opts.Scale = value
+		}
+	}
+
+	if dpi != "" {
+		value, err := strconv.Atoi(dpi)
+		if err != nil || value <= 0 || value > maxDPI {
+			return RenderOptions{}, fmt.Errorf("dpi must be a number between 1 and %d", maxDPI)
+		}
+		opts.DPI = value
+	}
+
+	// Other themes would reach PlantUML and come back as an error image
+	if theme != "" && !slices.Contains(Themes, theme) {
+		return RenderOptions{}, fmt.Errorf("unknown theme %q", theme)
+	}
+
+	return opts, nil
+}
+
+// IsZero reports whether no option is set.
+func (opts RenderOptions) IsZero() bool {
+	return opts == RenderOptions{}
+}
+
+// Key identifies the option set in file names and cache keys.
+func (opts RenderOptions) Key() string {
+	parts := []string{}
+	if opts.Scale != 0 {
+		parts = append(parts, "scale-"+strconv.FormatFloat(opts.Scale, 'f', -1, 64))
+	}
+	if opts.DPI != 0 {
+		parts = append(parts, "dpi-"+strconv.Itoa(opts.DPI))
+	}
+	if opts.Theme != "" {
+		parts = append(parts, "theme-"+opts.Theme)
+	}
+
+	return strings.Join(parts, "_")
+}
+
+// Apply adds the directives for the options to every diagram in source. The
+// theme goes right after the @start line like PlantUML expects, so the
+// diagram's own settings still apply on top of it. Scale and DPI are placed
+// right before the @end line so they win over the diagram's own settings.
+func (opts RenderOptions) Apply(source string) string {
+	first := []string{}
+	if opts.Theme != "" {
+		first = append(first, "!theme "+opts.Theme)
+	}
+	last := []string{}
+	if opts.DPI != 0 {
+		last = append(last, "skinparam dpi "+strconv.Itoa(opts.DPI))
+	}
+	if opts.Scale != 0 {
+		last = append(last, "scale "+strconv.FormatFloat(opts.Scale, 'f', -1, 64))
+	}
+	if len(first) == 0 && len(last) == 0 {
+		return source
+	}
+
+	lines := strings.Split(source, "\n")
+	out := make([]string, 0, len(lines)+len(first)+len(last))
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "@end") {
+			out = append(out, last...)
+		}
+		out = append(out, line)
+		if strings.HasPrefix(trimmed, "@start") {
+			out = append(out, first...)
+		}
+	}
+
+	return strings.Join(out, "\n")
+}
@@ -0,0 +1,270 @@
+package plantuml
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sync"
+
+	"github.com/platforma-dev/platforma/log"
+)
+
+// pipeDelimiter is printed by PlantUML after every diagram in -pipe mode.
+const pipeDelimiter = "___PUMLWS_DIAGRAM_END___"
+
+var (
+	errPoolClosed = errors.New("plantuml worker pool is closed")
+
+	pipeErrorTrailer = regexp.MustCompile(`^ERROR\r?\n(-?\d+)\r?\n`)
+)
+
+// worker is a long-lived "java -jar plantuml.jar -pipe" process rendering a
+// single output format.
+type worker struct {
+	format string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+
+	closeOnce sync.Once
+}
+
+func startWorker(ctx context.Context, jarPath string, javaOptions []string, format string) (*worker, error) {
+	flag, _ := formatFlag(format)
+	cmd := exec.Command("java", javaArgs(append([]string{"-Djava.awt.headless=true"}, javaOptions...), jarPath,
+		"-pipe",
+		"-pipeNoStderr",
+		"-pipedelimitor", pipeDelimiter,
+		"-charset", "UTF-8",
+		flag,
+	)...)
+	startInProcessGroup(cmd)
+	cmd.WaitDelay = processWaitDelay
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start plantuml worker: %w", err)
+	}
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			log.WarnContext(ctx, "plantuml worker output", "format", format, "output", scanner.Text())
+		}
+	}()
+
+	log.InfoContext(ctx, "started plantuml worker", "format", format, "pid", cmd.Process.Pid)
+
+	return &worker{
+		format: format,
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: bufio.NewReader(stdout),
+	}, nil
+}
+
+// render feeds one diagram to the process and reads back its output. The
+// returned error text is PlantUML's "ERROR / line / message" report, empty if
+// the diagram rendered cleanly.
+func (w *worker) render(ctx context.Context, source string) ([]byte, string, error) {
+	type result struct {
+		out []byte
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		if _, err := io.WriteString(w.stdin, source); err != nil {
+			done <- result{err: err}
+			return
+		}
+
+		out, err := readUntilDelimiter(w.stdout)
+		done <- result{out: out, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		// The process is mid-diagram and can't be reused; the caller discards it.
+		w.close()
+		<-done
+		return nil, "", ctx.Err()
+	case res := <-done:
+		if res.err != nil {
+			return nil, "", fmt.Errorf("plantuml worker: %w", res.err)
+		}
+
+		image, errText := splitPipeError(res.out)
+		return image, errText, nil
+	}
+}
+
+func (w *worker) close() {
+	w.closeOnce.Do(func() {
+		_ = w.stdin.Close()
+		_ = killProcessTree(w.cmd)
+		_ = w.cmd.Wait()
+	})
+}
+
+// readUntilDelimiter reads one diagram worth of output.
+func readUntilDelimiter(r *bufio.Reader) ([]byte, error) {
+	var out []byte
+	for {
+		line, err := r.ReadBytes('\n')
+		out = append(out, line...)
+
+		trimmed := bytes.TrimRight(out, "\r\n")
+		if bytes.HasSuffix(trimmed, []byte(pipeDelimiter)) {
+			return trimmed[:len(trimmed)-len(pipeDelimiter)], nil
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// splitPipeError separates the error report PlantUML appends after the error
+// image in -pipeNoStderr mode from the image itself.
+func splitPipeError(out []byte) ([]byte, string) {
+	idx := bytes.LastIndex(out, []byte("ERROR\n"))
+	if crIdx := bytes.LastIndex(out, []byte("ERROR\r\n")); crIdx > idx {
+		idx = crIdx
+	}
+	if idx < 0 || !pipeErrorTrailer.Match(out[idx:]) {
+		return out, ""
+	}
+
+	return out[:idx], string(bytes.TrimSpace(out[idx:]))
+}
+
+// workerPool keeps up to size warm workers per output format.
+type workerPool struct {
+	jarPath     string
+	javaOptions []string
+	size        int
+
+	mu     sync.Mutex
+	closed bool
+	slots  map[string]chan *worker
+}
+
+func newWorkerPool(jarPath string, javaOptions []string, size int) *workerPool {
+	return &workerPool{
+		jarPath:     jarPath,
+		javaOptions: javaOptions,
+		size:        size,
+		slots:       make(map[string]chan *worker),
+	}
+}
+
+func (p *workerPool) formatSlots(format string) (chan *worker, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, errPoolClosed
+	}
+
+	slots, ok := p.slots[format]
+	if !ok {
+		// A nil entry is a free slot whose process has not been started yet
+		slots = make(chan *worker, p.size)
+		for range p.size {
+			slots <- nil
+		}
+		p.slots[format] = slots
+	}
+
+	return slots, nil
+}
+
+// render runs source through a warm worker for format, starting one if the
+// free slot has none.
+func (p *workerPool) render(ctx context.Context, format, source string) ([]byte, string, error) {
+	slots, err := p.formatSlots(format)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var w *worker
+	select {
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	case w = <-slots:
+	}
+
+	if w == nil {
+		w, err = startWorker(context.WithoutCancel(ctx), p.jarPath, p.javaOptions, format)
+		if err != nil {
+			slots <- nil
+			return nil, "", err
+		}
+	}
+
+	image, errText, err := w.render(ctx, source)
+	if err != nil {
+		w.close()
+		w = nil
+	}
+
+	p.release(slots, w)
+	return image, errText, err
+}
+
+func (p *workerPool) release(slots chan *worker, w *worker) {
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+
+	if closed && w != nil {
+		w.close()
+		w = nil
+	}
+
+	slots <- w
+}
+
+// close stops every idle worker; busy ones are stopped when released.
+func (p *workerPool) close() {
+	p.mu.Lock()
+	p.closed = true
+	slots := p.slots
+	p.mu.Unlock()
+
+	for _, formatSlots := range slots {
+		for range cap(formatSlots) {
+			select {
+			case w := <-formatSlots:
+				if w != nil {
+					w.close()
+				}
+				formatSlots <- nil
+			default:
+			}
+		}
+	}
+}
@@ -2,20 +2,108 @@ package plantuml
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/platforma-dev/platforma/log"
 )
 
+// processWaitDelay bounds how long a cancelled render waits for its output
+// pipes to close.
+const processWaitDelay = 2 * time.Second
+
+// Renderer turns PlantUML source files into diagram files.
+type Renderer interface {
+	// ExecuteWithFormat renders every diagram in input into the output
+	// directory and returns the renderer's messages.
+	ExecuteWithFormat(ctx context.Context, input, output, format string) (string, error)
+	// Version identifies the renderer and its PlantUML version, so outputs
+	// cached from another one are not reused.
+	Version() string
+}
+
+// sandboxProfile is the PlantUML security profile of sandboxed renderers. It
+// denies access to local files and environment variables, URLs stay allowed.
+const sandboxProfile = "INTERNET"
+
+// PlantUML renders diagrams with a local plantuml.jar.
 type PlantUML struct {
 	jarPath string
+	// javaOptions are passed to java before the jar
+	javaOptions []string
+	pool        *workerPool
+
+	versionOnce sync.Once
+	version     string
+}
+
+// New creates a runner for the PlantUML jar. With workers > 0 diagrams are
+// rendered by up to that many persistent PlantUML processes per output
+// format; with 0 a new java process is started for every render.
+func New(jarPath string, workers int) *PlantUML {
+	return newPlantUML(jarPath, nil, workers)
+}
+
+// NewSandboxed creates a runner for diagram text from untrusted clients, with
+// its own worker processes. PlantUML runs them with the INTERNET security
+// profile, so diagrams can't read local files or environment variables.
+func NewSandboxed(jarPath string, workers int) *PlantUML {
+	return newPlantUML(jarPath, []string{"-DPLANTUML_SECURITY_PROFILE=" + sandboxProfile}, workers)
+}
+
+func newPlantUML(jarPath string, javaOptions []string, workers int) *PlantUML {
+	puml := &PlantUML{jarPath: jarPath, javaOptions: javaOptions}
+	if workers > 0 {
+		puml.pool = newWorkerPool(jarPath, javaOptions, workers)
+	}
+
+	return puml
 }
 
-func New(jarPath string) *PlantUML {
-	return &PlantUML{jarPath: jarPath}
+// Run keeps the worker processes alive until ctx is cancelled.
+func (puml *PlantUML) Run(ctx context.Context) error {
+	<-ctx.Done()
+
+	if puml.pool != nil {
+		puml.pool.close()
+	}
+
+	return nil
+}
+
+// Version fingerprints the content of the jar, so replacing it with another
+// PlantUML release changes the version. Sandboxed renderers have their own
+// versions, their renders differ.
+func (puml *PlantUML) Version() string {
+	puml.versionOnce.Do(func() {
+		puml.version = "jar:" + puml.jarPath
+
+		jar, err := os.Open(puml.jarPath)
+		if err == nil {
+			defer jar.Close()
+
+			hash := sha256.New()
+			if _, err := io.Copy(hash, jar); err == nil {
+				puml.version = "jar:" + hex.EncodeToString(hash.Sum(nil))
+			}
+		}
+
+		if len(puml.javaOptions) > 0 {
+			puml.version += " " + strings.Join(puml.javaOptions, " ")
+		}
+	})
+
+	return puml.version
 }
 
 func (puml *PlantUML) Execute(ctx context.Context, input, output string) (string, error) {
@@ -29,20 +117,23 @@ func (puml *PlantUML) ExecuteWithFormat(ctx context.Context, input, output, form
 		return "", err
 	}
 
-	// Map format to PlantUML flag
-	var formatFlag string
-	switch format {
-	case "svg":
-		formatFlag = "-tsvg"
-	case "png":
-		formatFlag = "-tpng"
-	default:
+	if _, ok := formatFlag(format); !ok {
 		log.WarnContext(ctx, "unknown format, defaulting to SVG", "format", format)
-		formatFlag = "-tsvg"
+		format = "svg"
+	}
+
+	if puml.pool != nil {
+		source, err := os.ReadFile(input)
+		if err == nil && !needsOwnFile(string(source)) {
+			return puml.executeWithWorkers(ctx, input, output, format)
+		}
 	}
 
-	javaArgs := []string{"-jar", puml.jarPath, "-o", output, formatFlag, input}
-	pumlCmd := exec.CommandContext(ctx, "java", javaArgs...)
+	flag, _ := formatFlag(format)
+	pumlCmd := exec.CommandContext(ctx, "java", javaArgs(puml.javaOptions, puml.jarPath, "-o", output, flag, input)...)
+	startInProcessGroup(pumlCmd)
+	pumlCmd.Cancel = func() error { return killProcessTree(pumlCmd) }
+	pumlCmd.WaitDelay = processWaitDelay
 
 	pumlOut, err := pumlCmd.CombinedOutput()
 	outputText := strings.TrimSpace(string(pumlOut))
@@ -67,3 +158,49 @@ func (puml *PlantUML) ExecuteWithFormat(ctx context.Context, input, output, form
 
 	return outputText, nil
 }
+
+// executeWithWorkers renders every diagram of input through the worker pool.
+func (puml *PlantUML) executeWithWorkers(ctx context.Context, input, output, format string) (string, error) {
+	inputDir := filepath.Dir(input)
+
+	return renderBlocks(ctx, input, output, format, func(ctx context.Context, block Block) ([]byte, *blockError, error) {
+		image, errText, err := puml.pool.render(ctx, format, AbsoluteIncludes(block.Source, inputDir))
+		if err != nil || errText == "" {
+			return image, nil, err
+		}
+
+		return image, parsePipeError(errText), nil
+	})
+}
+
+var (
+	newpageLine   = regexp.MustCompile(`(?m)^\s*newpage\b`)
+	imageRef      = regexp.MustCompile(`<img:([^>{]*)[>{]`)
+	themeFromLine = regexp.MustCompile(`(?m)^\s*!theme\s+\S+\s+from\s+(\S+)`)
+	pathFunction  = regexp.MustCompile(`%(dirpath|filename|file_exists|load_json)\(`)
+)
+
+// needsOwnFile reports whether source has to be rendered from its file by
+// the command line instead of a worker. In -pipe mode PlantUML outputs only
+// the first page of a diagram, and resolves relative paths other than
+// includes against its own working directory.
+func needsOwnFile(source string) bool {
+	if newpageLine.MatchString(source) || pathFunction.MatchString(source) {
+		return true
+	}
+
+	for _, pattern := range []*regexp.Regexp{imageRef, themeFromLine} {
+		for _, match := range pattern.FindAllStringSubmatch(source, -1) {
+			if !strings.Contains(match[1], "://") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// javaArgs returns the java command line running the jar with args.
+func javaArgs(javaOptions []string, jarPath string, args ...string) []string {
+	return slices.Concat(javaOptions, []string{"-jar", jarPath}, args)
+}
@@ -0,0 +1,299 @@
+package plantuml
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitBlocksKeepsNamesAndStartLines(t *testing.T) {
+	t.Parallel()
+
+	source := "' header comment\n@startuml\nA -> B\n@enduml\n\n@startuml second\nB -> C\n@enduml\n@startmindmap(id=map)\n* root\n@endmindmap\n"
+
+	blocks := SplitBlocks(source)
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %d: %#v", len(blocks), blocks)
+	}
+
+	if blocks[0].Name != "" || blocks[0].StartLine != 2 || blocks[0].Source != "@startuml\nA -> B\n@enduml\n" {
+		t.Fatalf("unexpected first block: %#v", blocks[0])
+	}
+	if blocks[1].Name != "second" || blocks[1].StartLine != 6 {
+		t.Fatalf("unexpected second block: %#v", blocks[1])
+	}
+	if blocks[2].Name != "" || blocks[2].StartLine != 9 {
+		t.Fatalf("expected id attribute not to be used as name: %#v", blocks[2])
+	}
+}
+
+func TestSplitBlocksReportsUnclosedBlock(t *testing.T) {
+	t.Parallel()
+
+	blocks, unclosed := splitBlocks("@startuml\nA -> B\n@enduml\n@startuml second\nB -> C\n")
+	if len(blocks) != 1 {
+		t.Fatalf("expected the closed block only, got %#v", blocks)
+	}
+	if unclosed == nil || unclosed.Line != 4 || unclosed.Message != "No @end line closing @startuml, expected @enduml" {
+		t.Fatalf("expected an error on the unclosed @startuml, got %#v", unclosed)
+	}
+
+	if _, unclosed := splitBlocks("@startuml\nA -> B\n@enduml\n"); unclosed != nil {
+		t.Fatalf("expected no error for closed blocks, got %#v", unclosed)
+	}
+}
+
+func TestOutputFileNamesFollowPlantUMLConventions(t *testing.T) {
+	t.Parallel()
+
+	blocks := []Block{{}, {Name: "named"}, {}, {Name: "other.svg"}}
+
+	names := OutputFileNames(filepath.Join("in", "diagram.puml"), blocks, "svg")
+	if want := []string{"diagram.svg", "named.svg", "diagram_001.svg", "other.svg"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("unexpected names: got %v want %v", names, want)
+	}
+}
+
+func TestAbsoluteIncludesRewritesOnlyLocalRelativePaths(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(string(filepath.Separator), "diagrams", "nested")
+	source := strings.Join([]string{
+		"@startuml",
+		"!include _styles.puml",
+		"  !includesub ../shared/parts.puml!BASIC",
+		"!include <C4/C4_Container>",
+		"!include https://example.com/remote.puml",
+		"!include " + filepath.ToSlash(filepath.Join(dir, "abs.puml")),
+		"@enduml",
+	}, "\n")
+
+	got := AbsoluteIncludes(source, dir)
+	want := strings.Join([]string{
+		"@startuml",
+		"!include " + filepath.ToSlash(filepath.Join(dir, "_styles.puml")),
+		"  !includesub " + filepath.ToSlash(filepath.Join(dir, "..", "shared", "parts.puml")) + "!BASIC",
+		"!include <C4/C4_Container>",
+		"!include https://example.com/remote.puml",
+		"!include " + filepath.ToSlash(filepath.Join(dir, "abs.puml")),
+		"@enduml",
+	}, "\n")
+
+	if got != want {
+		t.Fatalf("unexpected rewrite:\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestReadUntilDelimiterSplitsConsecutiveDiagrams(t *testing.T) {
+	t.Parallel()
+
+	stream := "<svg>one</svg>" + pipeDelimiter + "\n<svg>two\n</svg>\n" + pipeDelimiter + "\r\n"
+	reader := bufio.NewReader(strings.NewReader(stream))
+
+	first, err := readUntilDelimiter(reader)
+	if err != nil || string(first) != "<svg>one</svg>" {
+		t.Fatalf("unexpected first diagram %q (err %v)", first, err)
+	}
+
+	second, err := readUntilDelimiter(reader)
+	if err != nil || string(second) != "<svg>two\n</svg>\n" {
+		t.Fatalf("unexpected second diagram %q (err %v)", second, err)
+	}
+
+	if _, err := readUntilDelimiter(reader); err == nil {
+		t.Fatal("expected error once the stream ends mid-diagram")
+	}
+}
+
+func TestSplitPipeErrorSeparatesImageAndReport(t *testing.T) {
+	t.Parallel()
+
+	image, errText := splitPipeError([]byte("<svg>error image</svg>ERROR\n3\nSyntax Error?\n"))
+	if string(image) != "<svg>error image</svg>" {
+		t.Fatalf("unexpected image %q", image)
+	}
+	if errText != "ERROR\n3\nSyntax Error?" {
+		t.Fatalf("unexpected error text %q", errText)
+	}
+
+	if blockErr := parsePipeError(errText); blockErr.Line != 3 || blockErr.Message != "Syntax Error?" {
+		t.Fatalf("unexpected parsed error %#v", blockErr)
+	}
+
+	image, errText = splitPipeError([]byte("<svg><text>ERROR</text></svg>"))
+	if errText != "" || string(image) != "<svg><text>ERROR</text></svg>" {
+		t.Fatalf("expected clean output to pass through, got %q / %q", image, errText)
+	}
+}
+
+func TestParseDiagnostics(t *testing.T) {
+	t.Parallel()
+
+	output := "Warning: no image in /in/empty.puml\nError line 7 in file: /in/flow.puml\nSyntax Error?\nError line 12 in file: /in/flow.puml\nSome diagram description contains errors\n"
+
+	got := ParseDiagnostics(output)
+	want := []Diagnostic{
+		{Severity: SeverityWarning, Message: "no image in /in/empty.puml"},
+		{File: "/in/flow.puml", Line: 7, Severity: SeverityError, Message: "Syntax Error?"},
+		{File: "/in/flow.puml", Line: 12, Severity: SeverityError, Message: "Some diagram description contains errors"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected diagnostics:\ngot  %#v\nwant %#v", got, want)
+	}
+
+	got = ParseDiagnostics("Rendering timed out after 2m0s")
+	if len(got) != 1 || got[0].Line != 0 || got[0].Message != "Rendering timed out after 2m0s" {
+		t.Fatalf("unexpected diagnostics for plain output: %#v", got)
+	}
+
+	if got := ParseDiagnostics(""); len(got) != 0 {
+		t.Fatalf("expected no diagnostics for empty output, got %#v", got)
+	}
+}
+
+func TestFormatsMapToPlantUMLFlagsAndExtensions(t *testing.T) {
+	tests := []struct {
+		format    string
+		flag      string
+		extension string
+	}{
+		{"svg", "-tsvg", "svg"},
+		{"pdf", "-tpdf", "pdf"},
+		{"latex", "-tlatex", "tex"},
+		{"txt", "-ttxt", "atxt"},
+		{"utxt", "-tutxt", "utxt"},
+		{"xmi", "-txmi", "xmi"},
+	}
+
+	for _, tt := range tests {
+		flag, ok := formatFlag(tt.format)
+		if !ok || flag != tt.flag {
+			t.Fatalf("%s: expected flag %s, got %s (%v)", tt.format, tt.flag, flag, ok)
+		}
+		if ext := FormatExtension(tt.format); ext != tt.extension {
+			t.Fatalf("%s: expected extension %s, got %s", tt.format, tt.extension, ext)
+		}
+	}
+
+	if _, ok := formatFlag("gif"); ok {
+		t.Fatal("expected unknown format to be reported")
+	}
+	if names := OutputFileNames("dir/a.puml", []Block{{}}, "txt"); names[0] != "a.atxt" {
+		t.Fatalf("unexpected output name %v", names)
+	}
+}
+
+func TestSandboxedRunsWithSecurityProfile(t *testing.T) {
+	jar := filepath.Join(t.TempDir(), "plantuml.jar")
+	if err := os.WriteFile(jar, []byte("jar"), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	puml, sandboxed := New(jar, 0), NewSandboxed(jar, 1)
+	if len(puml.javaOptions) != 0 {
+		t.Fatalf("expected no java options, got %v", puml.javaOptions)
+	}
+
+	args := javaArgs(sandboxed.pool.javaOptions, jar, "-pipe")
+	want := []string{"-DPLANTUML_SECURITY_PROFILE=INTERNET", "-jar", jar, "-pipe"}
+	if !slices.Equal(args, want) {
+		t.Fatalf("expected %v, got %v", want, args)
+	}
+
+	// Renders of the sandbox are not mixed up with unrestricted ones
+	if puml.Version() == sandboxed.Version() {
+		t.Fatalf("expected different versions, got %s", puml.Version())
+	}
+}
+
+func TestNeedsOwnFile(t *testing.T) {
+	for source, want := range map[string]bool{
+		"@startuml\nA -> B\n@enduml\n":                                     false,
+		"@startuml\nA -> B\nnewpage\nB -> C\n@enduml\n":                    true,
+		"@startuml\nA : <img:logo.png>\n@enduml\n":                         true,
+		"@startuml\nA : <img:https://example.com/logo.png>\n@enduml\n":     false,
+		"@startuml\n!theme mine from ./themes\nA -> B\n@enduml\n":          true,
+		"@startuml\n!theme mine from https://example.com/t\n@enduml\n":     false,
+		"@startuml\ntitle %dirpath()\n@enduml\n":                           true,
+		"@startuml\ntitle %filename()\n@enduml\n":                          true,
+		"@startuml\n!include common.iuml\n!theme plain\nA -> B\n@enduml\n": false,
+	} {
+		if got := needsOwnFile(source); got != want {
+			t.Errorf("needsOwnFile(%q) = %v, want %v", source, got, want)
+		}
+	}
+}
+
+func TestExecuteWithFormatFallsBackToCommandLine(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake java is a shell script")
+	}
+
+	// The fake java logs its arguments and fails in -pipe mode
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\necho \"$@\" >> " + calls + "\ncase \"$*\" in *-pipe*) exit 1 ;; esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "java"), []byte(script), 0o755); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	puml := New(filepath.Join(dir, "plantuml.jar"), 1)
+	t.Cleanup(puml.pool.close)
+
+	for source, wantPipe := range map[string]bool{
+		"@startuml\nA -> B\nnewpage\nB -> C\n@enduml\n": false,
+		"@startuml\nA : <img:logo.png>\n@enduml\n":      false,
+		"@startuml\nA -> B\n@enduml\n":                  true,
+	} {
+		if err := os.Remove(calls); err != nil && !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("remove failed: %v", err)
+		}
+		input := filepath.Join(dir, "flow.puml")
+		if err := os.WriteFile(input, []byte(source), 0o644); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+
+		_, err := puml.ExecuteWithFormat(context.Background(), input, filepath.Join(dir, "out"), "svg")
+		logged, _ := os.ReadFile(calls)
+		if usedPipe := strings.Contains(string(logged), "-pipe"); usedPipe != wantPipe {
+			t.Fatalf("expected -pipe use %v for %q, java was called with %q", wantPipe, source, logged)
+		}
+		if !wantPipe && err != nil {
+			t.Fatalf("expected the command line render of %q to succeed, got %v", source, err)
+		}
+	}
+}
+
+func TestRenderOptions(t *testing.T) {
+	opts, err := ParseRenderOptions("1.5", "300", "plain")
+	if err != nil {
+		t.Fatalf("ParseRenderOptions returned error: %v", err)
+	}
+	if key := opts.Key(); key != "scale-1.5_dpi-300_theme-plain" {
+		t.Fatalf("unexpected key %q", key)
+	}
+
+	// The theme comes first so the diagram's own skinparams override it
+	source := "@startuml\nskinparam backgroundColor red\nA -> B\n@enduml\n@startuml\nC -> D\n@enduml\n"
+	want := "@startuml\n!theme plain\nskinparam backgroundColor red\nA -> B\nskinparam dpi 300\nscale 1.5\n@enduml\n@startuml\n!theme plain\nC -> D\nskinparam dpi 300\nscale 1.5\n@enduml\n"
+	if got := opts.Apply(source); got != want {
+		t.Fatalf("unexpected source:\n%s", got)
+	}
+
+	if opts, err := ParseRenderOptions("1", "", ""); err != nil || !opts.IsZero() {
+		t.Fatalf("expected scale 1 to be the default, got %#v (%v)", opts, err)
+	}
+	for _, args := range [][3]string{{"0", "", ""}, {"", "-1", ""}, {"", "", "../x"}, {"", "", "nosuchtheme"}} {
+		if _, err := ParseRenderOptions(args[0], args[1], args[2]); err == nil {
+			t.Fatalf("expected error for %v", args)
+		}
+	}
+}
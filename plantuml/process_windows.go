@@ -0,0 +1,22 @@
+//go:build windows
+
+package plantuml
+
+import (
+	"os/exec"
+	"strconv"
+)
+
+func startInProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessTree(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+		return cmd.Process.Kill()
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package plantuml
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/platforma-dev/platforma/log"
+)
+
+// blockError is a syntax error PlantUML reported for one diagram block.
+type blockError struct {
+	// Line is 1-based and relative to the @start line of the block, or to the
+	// start of File if set.
+	Line int
+	// File is the included file the error is in, empty for the input itself.
+	File    string
+	Message string
+}
+
+// mapLine translates the line of an error in inlined source back to the file
+// it came from. lines are the origins of the inlined lines.
+func (e *blockError) mapLine(lines []SourceLine) {
+	if e.Line < 1 || e.Line > len(lines) {
+		return
+	}
+
+	origin := lines[e.Line-1]
+	e.File, e.Line = origin.File, origin.Line
+}
+
+// renderBlockFunc renders a single diagram. A diagram with syntax errors still
+// yields PlantUML's error image alongside the reported error.
+type renderBlockFunc func(ctx context.Context, block Block) ([]byte, *blockError, error)
+
+// renderBlocks renders every diagram of input one by one and writes the
+// outputs under the names PlantUML itself would use, so renderers that work
+// on diagram text behave like the plantuml.jar command line.
+func renderBlocks(ctx context.Context, input, output, format string, render renderBlockFunc) (string, error) {
+	source, err := os.ReadFile(input)
+	if err != nil {
+		return err.Error(), fmt.Errorf("plantuml %s generation failed: %w", format, err)
+	}
+
+	blocks, unclosed := splitBlocks(string(source))
+	names := OutputFileNames(input, blocks, format)
+
+	messages := []string{}
+	for i, block := range blocks {
+		image, blockErr, err := render(ctx, block)
+		if err != nil {
+			log.ErrorContext(ctx, "plantuml render failed", "input", input, "error", err)
+			return err.Error(), fmt.Errorf("plantuml %s generation failed: %w", format, err)
+		}
+
+		outputFile := filepath.Join(output, names[i])
+		if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+			return err.Error(), fmt.Errorf("plantuml %s generation failed: %w", format, err)
+		}
+		if err := os.WriteFile(outputFile, image, 0644); err != nil {
+			return err.Error(), fmt.Errorf("plantuml %s generation failed: %w", format, err)
+		}
+
+		if blockErr != nil {
+			file, line := input, block.StartLine+blockErr.Line-1
+			if blockErr.File != "" {
+				file, line = blockErr.File, blockErr.Line
+			}
+			messages = append(messages, fmt.Sprintf("Error line %d in file: %s\n%s", line, file, blockErr.Message))
+		}
+	}
+
+	// PlantUML fails on a diagram that is never closed, instead of skipping it
+	if unclosed != nil {
+		messages = append(messages, fmt.Sprintf("Error line %d in file: %s\n%s", unclosed.Line, input, unclosed.Message))
+	}
+
+	if len(messages) > 0 {
+		outputText := strings.Join(messages, "\n")
+		log.InfoContext(ctx, "plantuml output", "output", outputText)
+		return outputText, fmt.Errorf("plantuml %s generation failed: %w", format, errors.New("diagram contains errors"))
+	}
+
+	return "", nil
+}
+
+// parsePipeError parses the "ERROR / line / message" report of -pipe mode.
+func parsePipeError(errText string) *blockError {
+	match := pipeErrorTrailer.FindStringSubmatch(errText)
+	if match == nil {
+		return &blockError{Line: 1, Message: strings.TrimSpace(errText)}
+	}
+
+	line, err := strconv.Atoi(match[1])
+	if err != nil {
+		line = 1
+	}
+
+	return &blockError{Line: line, Message: strings.TrimSpace(errText[len(match[0]):])}
+}
@@ -0,0 +1,220 @@
+// Package rendercache stores rendered diagrams on disk by the hash of
+// everything that went into rendering them, so identical inputs are not
+// rendered twice, across files and restarts.
+package rendercache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps entries in a directory, evicting the least recently used ones
+// once their total size exceeds the limit.
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]*list.Element
+	// recent holds *entry values, most recently used first
+	recent *list.List
+}
+
+type entry struct {
+	key  string
+	size int64
+}
+
+// Key hashes the parts describing a render into a cache key.
+func Key(parts ...string) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(hash, "%d:%s", len(part), part)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// New opens the cache in dir, picking up entries stored by earlier runs.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	c := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		recent:   list.New(),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type stored struct {
+		entry
+		used time.Time
+	}
+	found := []stored{}
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+
+		// Left behind by a Put that never finished
+		if strings.HasPrefix(dirEntry.Name(), ".") {
+			os.RemoveAll(filepath.Join(dir, dirEntry.Name()))
+			continue
+		}
+
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+
+		found = append(found, stored{
+			entry: entry{key: dirEntry.Name(), size: dirSize(filepath.Join(dir, dirEntry.Name()))},
+			used:  info.ModTime(),
+		})
+	}
+
+	// The entry directory's modification time records its last use
+	slices.SortFunc(found, func(a, b stored) int { return b.used.Compare(a.used) })
+	for _, stored := range found {
+		c.entries[stored.key] = c.recent.PushBack(&stored.entry)
+		c.size += stored.size
+	}
+	c.evict()
+
+	return c, nil
+}
+
+// Get returns the files stored under key in the order they were put.
+func (c *Cache) Get(key string) ([][]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entryDir := filepath.Join(c.dir, key)
+	files := [][]byte{}
+	for i := 0; ; i++ {
+		data, err := os.ReadFile(filepath.Join(entryDir, strconv.Itoa(i)))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			c.remove(elem)
+			return nil, false
+		}
+		files = append(files, data)
+	}
+
+	c.recent.MoveToFront(elem)
+	now := time.Now()
+	_ = os.Chtimes(entryDir, now, now)
+
+	return files, true
+}
+
+// Put stores files under key and evicts old entries if the cache grew too
+// large.
+func (c *Cache) Put(key string, files [][]byte) error {
+	size := int64(0)
+	for _, data := range files {
+		size += int64(len(data))
+	}
+	if size > c.maxBytes {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.recent.MoveToFront(elem)
+		return nil
+	}
+
+	// Write into a temporary directory so readers never see a partial entry
+	tmpDir, err := os.MkdirTemp(c.dir, ".put-")
+	if err != nil {
+		return err
+	}
+	for i, data := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, strconv.Itoa(i)), data, 0o644); err != nil {
+			os.RemoveAll(tmpDir)
+			return err
+		}
+	}
+	if err := os.Rename(tmpDir, filepath.Join(c.dir, key)); err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+
+	c.entries[key] = c.recent.PushFront(&entry{key: key, size: size})
+	c.size += size
+	c.evict()
+
+	return nil
+}
+
+// Size returns the total size of the stored files.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
+}
+
+// evict drops least recently used entries until the cache fits. c.mu must be
+// held.
+func (c *Cache) evict() {
+	for c.size > c.maxBytes {
+		oldest := c.recent.Back()
+		if oldest == nil {
+			return
+		}
+		c.remove(oldest)
+	}
+}
+
+// remove deletes an entry. c.mu must be held.
+func (c *Cache) remove(elem *list.Element) {
+	e := elem.Value.(*entry)
+	c.recent.Remove(elem)
+	delete(c.entries, e.key)
+	c.size -= e.size
+	os.RemoveAll(filepath.Join(c.dir, e.key))
+}
+
+func dirSize(dir string) int64 {
+	size := int64(0)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+
+	for _, file := range files {
+		if info, err := file.Info(); err == nil {
+			size += info.Size()
+		}
+	}
+
+	return size
+}
@@ -0,0 +1,94 @@
+package rendercache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPutAndGet(t *testing.T) {
+	t.Parallel()
+
+	c, err := New(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	key := Key("jar:abc", "svg", "hash")
+	files := [][]byte{[]byte("<svg>first</svg>"), []byte("<svg>second</svg>")}
+	if err := c.Put(key, files); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	got, ok := c.Get(key)
+	if !ok {
+		t.Fatal("expected cache hit")
+	}
+	if !reflect.DeepEqual(got, files) {
+		t.Fatalf("unexpected files %q", got)
+	}
+
+	if _, ok := c.Get(Key("jar:abc", "png", "hash")); ok {
+		t.Fatal("expected miss for another format")
+	}
+}
+
+func TestKeySeparatesParts(t *testing.T) {
+	t.Parallel()
+
+	if Key("ab", "c") == Key("a", "bc") {
+		t.Fatal("expected different keys for differently split parts")
+	}
+}
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	t.Parallel()
+
+	c, err := New(t.TempDir(), 10)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	c.Put("a", [][]byte{[]byte("aaaa")})
+	c.Put("b", [][]byte{[]byte("bbbb")})
+	c.Get("a")
+	c.Put("c", [][]byte{[]byte("cccc")})
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected least recently used entry to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected recently used entry to stay")
+	}
+	if c.Size() != 8 {
+		t.Fatalf("expected size 8, got %d", c.Size())
+	}
+
+	if err := c.Put("huge", [][]byte{make([]byte, 11)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := c.Get("huge"); ok {
+		t.Fatal("entries larger than the cache must not be stored")
+	}
+}
+
+func TestNewLoadsExistingEntries(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	first, err := New(dir, 1024)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	first.Put("key", [][]byte{[]byte("data")})
+
+	second, err := New(dir, 1024)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got, ok := second.Get("key"); !ok || string(got[0]) != "data" {
+		t.Fatalf("expected entry from earlier run, got %q %v", got, ok)
+	}
+	if second.Size() != 4 {
+		t.Fatalf("expected size 4, got %d", second.Size())
+	}
+}
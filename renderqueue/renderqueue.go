@@ -0,0 +1,115 @@
+// Package renderqueue limits how many diagrams render at once and decides
+// which waiting render goes next.
+package renderqueue
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+type Priority int
+
+const (
+	// Background is used for bulk work such as the initial generation.
+	Background Priority = iota
+	// Interactive is used for diagrams someone is currently looking at.
+	Interactive
+)
+
+type waiter struct {
+	key      string
+	priority Priority
+	ready    chan struct{}
+	granted  bool
+}
+
+// Queue hands out a limited number of render slots. Waiters with a higher
+// priority are admitted first, waiters of equal priority in arrival order.
+type Queue struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	waiting []*waiter
+}
+
+func New(limit int) *Queue {
+	return &Queue{limit: max(limit, 1)}
+}
+
+// Acquire blocks until a render slot for key is free or ctx is done. The
+// returned function must be called to give the slot back.
+func (q *Queue) Acquire(ctx context.Context, key string, priority Priority) (func(), error) {
+	q.mu.Lock()
+	if q.running < q.limit && len(q.waiting) == 0 {
+		q.running++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+
+	w := &waiter{key: key, priority: priority, ready: make(chan struct{})}
+	q.waiting = append(q.waiting, w)
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+
+		if w.granted {
+			// The slot was handed over while we gave up; pass it on
+			q.releaseLocked()
+		} else {
+			q.waiting = slices.DeleteFunc(q.waiting, func(other *waiter) bool { return other == w })
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Promote raises the priority of renders waiting for key.
+func (q *Queue) Promote(key string, priority Priority) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, w := range q.waiting {
+		if w.key == key && w.priority < priority {
+			w.priority = priority
+		}
+	}
+}
+
+// Pending returns the number of renders waiting for a slot.
+func (q *Queue) Pending() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.waiting)
+}
+
+func (q *Queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.releaseLocked()
+}
+
+func (q *Queue) releaseLocked() {
+	if len(q.waiting) == 0 {
+		q.running--
+		return
+	}
+
+	next := 0
+	for i, w := range q.waiting {
+		if w.priority > q.waiting[next].priority {
+			next = i
+		}
+	}
+
+	w := q.waiting[next]
+	q.waiting = slices.Delete(q.waiting, next, next+1)
+	w.granted = true
+	close(w.ready)
+}
@@ -0,0 +1,89 @@
+package renderqueue
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestQueueAdmitsInteractiveBeforeBackground(t *testing.T) {
+	t.Parallel()
+
+	q := New(1)
+	release, err := q.Acquire(context.Background(), "busy", Background)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	var mu sync.Mutex
+	order := []string{}
+	var wg sync.WaitGroup
+	start := func(key string, priority Priority, pending int) {
+		wg.Go(func() {
+			release, err := q.Acquire(context.Background(), key, priority)
+			if err != nil {
+				t.Errorf("Acquire(%s) failed: %v", key, err)
+				return
+			}
+			mu.Lock()
+			order = append(order, key)
+			mu.Unlock()
+			release()
+		})
+		waitForPending(t, q, pending)
+	}
+
+	start("bulk-1", Background, 1)
+	start("bulk-2", Background, 2)
+	start("viewed", Interactive, 3)
+	start("bulk-3", Background, 4)
+	q.Promote("bulk-3", Interactive)
+
+	release()
+	wg.Wait()
+
+	if want := []string{"viewed", "bulk-3", "bulk-1", "bulk-2"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("unexpected admission order: got %v want %v", order, want)
+	}
+}
+
+func TestQueueAcquireHonoursCancellation(t *testing.T) {
+	t.Parallel()
+
+	q := New(1)
+	release, err := q.Acquire(context.Background(), "busy", Background)
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.Acquire(ctx, "late", Background); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+	if q.Pending() != 0 {
+		t.Fatalf("expected cancelled waiter to leave the queue, %d pending", q.Pending())
+	}
+
+	release()
+	release, err = q.Acquire(context.Background(), "next", Background)
+	if err != nil {
+		t.Fatalf("expected slot to be free again: %v", err)
+	}
+	release()
+}
+
+func waitForPending(t *testing.T, q *Queue, pending int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for q.Pending() < pending {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %d pending renders", pending)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
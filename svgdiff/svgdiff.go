@@ -0,0 +1,306 @@
+// Package svgdiff compares two SVGs rendered by PlantUML element by element
+// and marks what was added, removed or moved, so the differences can be
+// highlighted.
+package svgdiff
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Status is how an element of one SVG relates to the other SVG.
+type Status string
+
+const (
+	Added   Status = "added"
+	Removed Status = "removed"
+	// Moved elements are in both SVGs, but at a different position or with a
+	// different size.
+	Moved Status = "moved"
+)
+
+// Attribute is set on every element that differs, to the element's Status.
+const Attribute = "data-diff"
+
+// colors highlight the elements of every Status.
+var colors = map[Status]string{
+	Added:   "#16a34a",
+	Removed: "#dc2626",
+	Moved:   "#d97706",
+}
+
+// Result holds both SVGs with the elements that differ marked with
+// Attribute, plus the number of elements of every kind.
+type Result struct {
+	Before []byte
+	After  []byte
+
+	Added     int
+	Removed   int
+	Moved     int
+	Unchanged int
+}
+
+// Changed reports whether the SVGs differ at all.
+func (r Result) Changed() bool {
+	return r.Added > 0 || r.Removed > 0 || r.Moved > 0
+}
+
+// geometry are the attributes that place and size an element. Elements that
+// differ only in these have moved.
+var geometry = []string{"x", "y", "x1", "y1", "x2", "y2", "cx", "cy", "r", "rx", "ry", "width", "height", "points", "d", "transform", "textLength"}
+
+// containers group other elements, they are not compared themselves.
+var containers = []string{"svg", "g", "a", "switch"}
+
+// ignored elements hold no drawing, neither they nor their children are
+// compared.
+var ignored = []string{"defs", "style", "script", "title", "desc", "metadata"}
+
+// element is a leaf of the element tree of an SVG.
+type element struct {
+	// nameEnd is the offset right after the name in the start tag, where
+	// Attribute is inserted
+	nameEnd int
+	// key identifies the element with all of its attributes and text,
+	// identity without the attributes in geometry
+	key      string
+	identity string
+	matched  bool
+	status   Status
+}
+
+type parsed struct {
+	content  []byte
+	elements []*element
+	// rootEnd is the offset after the start tag of the root element
+	rootEnd int
+}
+
+// Compare compares the drawing elements of two SVGs. Elements match if they
+// have the same name, attributes and text, ids aside.
+func Compare(before, after []byte) (Result, error) {
+	b, err := parse(before)
+	if err != nil {
+		return Result{}, fmt.Errorf("parse before: %w", err)
+	}
+
+	a, err := parse(after)
+	if err != nil {
+		return Result{}, fmt.Errorf("parse after: %w", err)
+	}
+
+	result := Result{}
+	result.Unchanged = match(b.elements, a.elements, func(e *element) string { return e.key }, "")
+
+	// What's left over on both sides with the same identity has moved
+	removed := unmatched(b.elements)
+	added := unmatched(a.elements)
+	result.Moved = match(removed, added, func(e *element) string { return e.identity }, Moved)
+
+	for _, e := range b.elements {
+		if !e.matched {
+			e.status = Removed
+			result.Removed++
+		}
+	}
+	for _, e := range a.elements {
+		if !e.matched {
+			e.status = Added
+			result.Added++
+		}
+	}
+
+	result.Before = b.annotate()
+	result.After = a.annotate()
+
+	return result, nil
+}
+
+// match pairs the elements of before and after with the same key in
+// document order, sets status on both sides of every pair and returns the
+// number of pairs. Unchanged elements get no status.
+func match(before, after []*element, key func(*element) string, status Status) int {
+	candidates := map[string][]*element{}
+	for _, e := range after {
+		candidates[key(e)] = append(candidates[key(e)], e)
+	}
+
+	matched := 0
+	for _, e := range before {
+		k := key(e)
+		if len(candidates[k]) == 0 {
+			continue
+		}
+
+		other := candidates[k][0]
+		candidates[k] = candidates[k][1:]
+		e.matched, other.matched = true, true
+		e.status, other.status = status, status
+		matched++
+	}
+
+	return matched
+}
+
+func unmatched(elements []*element) []*element {
+	left := []*element{}
+	for _, e := range elements {
+		if !e.matched {
+			left = append(left, e)
+		}
+	}
+
+	return left
+}
+
+// frame is an element that is still open while parsing.
+type frame struct {
+	name     string
+	nameEnd  int
+	attrs    []xml.Attr
+	text     strings.Builder
+	children bool
+	ignored  bool
+}
+
+func parse(content []byte) (*parsed, error) {
+	p := &parsed{content: content, rootEnd: -1}
+	decoder := xml.NewDecoder(bytes.NewReader(content))
+	decoder.Entity = xml.HTMLEntity
+	// PlantUML declares us-ascii and escapes everything else. The content is
+	// read as is either way, so offsets stay those of the original bytes.
+	decoder.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	stack := []*frame{}
+	for {
+		offset := int(decoder.InputOffset())
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch token := token.(type) {
+		case xml.StartElement:
+			name := token.Name.Local
+			rawName := name
+			if token.Name.Space != "" {
+				rawName = token.Name.Space + ":" + name
+			}
+
+			f := &frame{name: name, nameEnd: offset + 1 + len(rawName), attrs: token.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = true
+				f.ignored = parent.ignored
+			} else {
+				p.rootEnd = int(decoder.InputOffset())
+			}
+			if slices.Contains(ignored, name) {
+				f.ignored = true
+			}
+			stack = append(stack, f)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text.Write(token)
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("unexpected end element %s", token.Name.Local)
+			}
+
+			f := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if f.children || f.ignored || slices.Contains(containers, f.name) {
+				continue
+			}
+
+			key, identity := f.keys()
+			p.elements = append(p.elements, &element{nameEnd: f.nameEnd, key: key, identity: identity})
+		}
+	}
+
+	if p.rootEnd < 0 {
+		return nil, errors.New("no root element")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("element %s is not closed", stack[len(stack)-1].name)
+	}
+
+	return p, nil
+}
+
+// keys returns the key and the identity of a leaf element.
+func (f *frame) keys() (string, string) {
+	attrs := []string{}
+	geometric := []string{}
+	for _, attr := range f.attrs {
+		name := attr.Name.Local
+		if attr.Name.Space != "" {
+			name = attr.Name.Space + ":" + name
+		}
+
+		// Ids are numbered by PlantUML, they change whenever elements are added
+		if name == "id" || strings.HasPrefix(name, "data-") || name == "xmlns" || attr.Name.Space == "xmlns" {
+			continue
+		}
+
+		if slices.Contains(geometry, name) {
+			geometric = append(geometric, name+"="+attr.Value)
+		} else {
+			attrs = append(attrs, name+"="+attr.Value)
+		}
+	}
+	slices.Sort(attrs)
+	slices.Sort(geometric)
+
+	text := strings.Join(strings.Fields(f.text.String()), " ")
+	identity := f.name + "\x00" + strings.Join(attrs, "\x00") + "\x00" + text
+	return identity + "\x00" + strings.Join(geometric, "\x00"), identity
+}
+
+// annotate returns the content with Attribute set on the elements that
+// differ and a style sheet highlighting them.
+func (p *parsed) annotate() []byte {
+	var out bytes.Buffer
+	last := 0
+	write := func(offset int, insert string) {
+		out.Write(p.content[last:offset])
+		out.WriteString(insert)
+		last = offset
+	}
+
+	// Elements come after the start tag of the root, in document order
+	write(p.rootEnd, styleSheet())
+	for _, e := range p.elements {
+		if e.status != "" {
+			write(e.nameEnd, " "+Attribute+`="`+string(e.status)+`"`)
+		}
+	}
+	out.Write(p.content[last:])
+
+	return out.Bytes()
+}
+
+func styleSheet() string {
+	var style strings.Builder
+	style.WriteString("<style>")
+	for _, status := range []Status{Added, Removed, Moved} {
+		color := colors[status]
+		fmt.Fprintf(&style, `[%s="%s"]{stroke:%s !important;stroke-width:2px !important}`, Attribute, status, color)
+		fmt.Fprintf(&style, `text[%s="%s"]{fill:%s !important;stroke:none !important}`, Attribute, status, color)
+	}
+	style.WriteString("</style>")
+
+	return style.String()
+}
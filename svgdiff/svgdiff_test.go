@@ -0,0 +1,77 @@
+package svgdiff
+
+import (
+	"strings"
+	"testing"
+)
+
+const beforeSVG = `<?xml version="1.0" encoding="us-ascii" standalone="no"?><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="200px" height="100px"><defs><filter id="f1"/></defs><g id="ent0001"><rect x="10" y="10" width="40" height="20" fill="#F1F1F1"/><text x="15" y="25">Alice</text></g><g id="ent0002"><rect x="100" y="10" width="40" height="20" fill="#F1F1F1"/><text x="105" y="25">Bob</text></g><line x1="50" y1="20" x2="100" y2="20" style="stroke:#181818;"/></svg>`
+
+const afterSVG = `<?xml version="1.0" encoding="us-ascii" standalone="no"?><svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="300px" height="100px"><defs><filter id="f1"/></defs><g id="ent0001"><rect x="10" y="10" width="40" height="20" fill="#F1F1F1"/><text x="15" y="25">Alice</text></g><g id="ent0003"><rect x="200" y="10" width="40" height="20" fill="#F1F1F1"/><text x="205" y="25">Carol</text></g><g id="ent0002"><rect x="100" y="50" width="40" height="20" fill="#F1F1F1"/><text x="105" y="65">Bob</text></g><a xlink:href="#x"><path d="M0 0"/></a></svg>`
+
+func TestCompareMarksDifferences(t *testing.T) {
+	t.Parallel()
+
+	result, err := Compare([]byte(beforeSVG), []byte(afterSVG))
+	if err != nil {
+		t.Fatalf("Compare failed: %v", err)
+	}
+
+	// Alice is unchanged, Bob moved, Carol and the path are new and the line
+	// is gone
+	if result.Unchanged != 2 || result.Moved != 2 || result.Added != 3 || result.Removed != 1 {
+		t.Fatalf("unexpected counts %+v", result)
+	}
+	if !result.Changed() {
+		t.Fatal("expected the SVGs to differ")
+	}
+
+	before := string(result.Before)
+	if !strings.Contains(before, `<line data-diff="removed" x1="50"`) {
+		t.Fatalf("expected the line to be marked removed, got %s", before)
+	}
+	if !strings.Contains(before, `<text data-diff="moved" x="105" y="25">Bob</text>`) {
+		t.Fatalf("expected Bob to be marked moved, got %s", before)
+	}
+	if !strings.Contains(before, `<text x="15" y="25">Alice</text>`) {
+		t.Fatalf("expected Alice to be left alone, got %s", before)
+	}
+
+	after := string(result.After)
+	for _, want := range []string{
+		`<text data-diff="added" x="205" y="25">Carol</text>`,
+		`<path data-diff="added" d="M0 0"/>`,
+		`<a xlink:href="#x">`,
+		`height="100px"><style>`,
+	} {
+		if !strings.Contains(after, want) {
+			t.Fatalf("expected %s in %s", want, after)
+		}
+	}
+}
+
+func TestCompareIdenticalSVGs(t *testing.T) {
+	t.Parallel()
+
+	result, err := Compare([]byte(beforeSVG), []byte(beforeSVG))
+	if err != nil {
+		t.Fatalf("Compare failed: %v", err)
+	}
+	if result.Changed() || result.Unchanged != 5 {
+		t.Fatalf("expected no differences, got %+v", result)
+	}
+	if strings.Contains(string(result.After), " "+Attribute+`="`) {
+		t.Fatalf("expected no marked elements, got %s", result.After)
+	}
+}
+
+func TestCompareRejectsInvalidSVG(t *testing.T) {
+	t.Parallel()
+
+	if _, err := Compare([]byte("<svg><g></svg>"), []byte(beforeSVG)); err == nil {
+		t.Fatal("expected error for invalid SVG")
+	}
+	if _, err := Compare([]byte(beforeSVG), []byte("")); err == nil {
+		t.Fatal("expected error for empty SVG")
+	}
+}